@@ -2,11 +2,12 @@ package commands
 
 import (
 	"fmt"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/a7medev/goredis/rdb"
+	"github.com/a7medev/goredis/config"
 	"github.com/a7medev/goredis/resp"
 	"github.com/a7medev/goredis/server"
 	"github.com/a7medev/goredis/storage"
@@ -63,6 +64,8 @@ func Set(ctx *server.Context) {
 	mode := storage.SetDefault
 	get := false
 	keepTTL := false
+	// expiryIndex is the index of the expiry argument, if any.
+	expiryIndex := -1
 
 	// Parse extra arguments to SET
 	for i := 2; i < len(ctx.Args); i++ {
@@ -124,6 +127,7 @@ func Set(ctx *server.Context) {
 			}
 
 			expiry = storage.NewExpiry(t, arg)
+			expiryIndex = i - 1
 
 		case "KEEPTTL":
 			keepTTL = true
@@ -141,6 +145,15 @@ func Set(ctx *server.Context) {
 
 	result, exists, isSet := ctx.DB.Set(key, value, expiry, mode, keepTTL, get)
 
+	// Relative expiry must be propagated as an absolute time, otherwise it would be extended when replayed.
+	if expiryIndex != -1 {
+		args := append([]string{}, ctx.Args...)
+		args[expiryIndex] = "PXAT"
+		args[expiryIndex+1] = strconv.FormatInt(expiry.Time.UnixMilli(), 10)
+
+		ctx.Propagate(ctx.Command, args...)
+	}
+
 	if ctx.FromMaster {
 		return
 	}
@@ -181,13 +194,7 @@ func Get(ctx *server.Context) {
 }
 
 func Del(ctx *server.Context) {
-	deleted := 0
-
-	for _, key := range ctx.Args {
-		if ok := ctx.DB.Delete(key); ok {
-			deleted++
-		}
-	}
+	deleted := ctx.DB.Delete(ctx.Args...)
 
 	if ctx.FromMaster {
 		return
@@ -205,6 +212,9 @@ func Info(ctx *server.Context) {
 
 	outputServer := len(ctx.Args) == 0
 	outputReplication := len(ctx.Args) == 0
+	outputPersistence := len(ctx.Args) == 0
+	outputStats := len(ctx.Args) == 0
+	outputMemory := len(ctx.Args) == 0
 
 	for _, section := range ctx.Args {
 		switch strings.ToLower(section) {
@@ -212,6 +222,12 @@ func Info(ctx *server.Context) {
 			outputServer = true
 		case "replication":
 			outputReplication = true
+		case "persistence":
+			outputPersistence = true
+		case "stats":
+			outputStats = true
+		case "memory":
+			outputMemory = true
 		}
 	}
 
@@ -221,51 +237,327 @@ func Info(ctx *server.Context) {
 		b.WriteString(ctx.Config.Server.String())
 	}
 
-	if outputReplication {
-		b.WriteString(ctx.Config.Replication.String())
+	if outputMemory {
+		b.WriteString(memoryInfo(ctx.DB, &ctx.Config.Memory))
 	}
 
 	ctx.Config.Mu.RUnlock()
 
+	if outputReplication {
+		b.WriteString(ctx.Replcation.String())
+	}
+
+	if outputPersistence {
+		b.WriteString(ctx.Persistence.String())
+	}
+
+	if outputStats {
+		b.WriteString(statsInfo(ctx.DB))
+	}
+
 	info := resp.NewBulkString(b.String())
 	ctx.Reply(info)
 }
 
+// memoryInfo returns the memory section of the INFO command.
+func memoryInfo(db *storage.Database, c *config.MemoryConfig) string {
+	b := strings.Builder{}
+
+	b.WriteString("# Memory\n")
+	b.WriteString(config.Entry("used_memory", db.UsedMemory()))
+	b.WriteString(config.Entry("maxmemory", c.MaxMemory))
+	b.WriteString(config.Entry("maxmemory_policy", c.MaxMemoryPolicy))
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// statsInfo returns the stats section of the INFO command.
+func statsInfo(db *storage.Database) string {
+	b := strings.Builder{}
+
+	b.WriteString("# Stats\n")
+	b.WriteString(config.Entry("expired_keys", db.ExpiredKeys()))
+	b.WriteString(config.Entry("evicted_keys", db.EvictedKeys()))
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+func Config(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) == 0 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'config' command"))
+		return
+	}
+
+	subcommand := strings.ToUpper(ctx.Args[0])
+
+	switch subcommand {
+	case "GET":
+		if len(ctx.Args) < 2 {
+			ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'config|get' command"))
+			return
+		}
+
+		values := make(map[string]string)
+
+		ctx.Config.Mu.RLock()
+
+		for _, pattern := range ctx.Args[1:] {
+			for name, value := range ctx.Config.Get(pattern) {
+				values[name] = value
+			}
+		}
+
+		ctx.Config.Mu.RUnlock()
+
+		names := make([]string, 0, len(values))
+
+		for name := range values {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		result := resp.NewArray()
+
+		for _, name := range names {
+			result.Append(resp.NewBulkString(name))
+			result.Append(resp.NewBulkString(values[name]))
+		}
+
+		ctx.Reply(result)
+
+	default:
+		msg := fmt.Sprintf("ERR unknown subcommand '%v'. Try CONFIG HELP.", ctx.Args[0])
+		ctx.Reply(resp.NewSimpleError(msg))
+	}
+}
+
+func Save(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if err := ctx.Persistence.Save(); err != nil {
+		fmt.Println("Error saving RDB file:", err.Error())
+		ctx.Reply(resp.NewSimpleError("ERR " + err.Error()))
+		return
+	}
+
+	ctx.Reply(resp.NewSimpleString("OK"))
+}
+
+func BgSave(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if err := ctx.Persistence.BgSave(); err != nil {
+		ctx.Reply(resp.NewSimpleError("ERR " + err.Error()))
+		return
+	}
+
+	ctx.Reply(resp.NewSimpleString("Background saving started"))
+}
+
+func BgRewriteAOF(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if err := ctx.Persistence.BgRewriteAOF(); err != nil {
+		ctx.Reply(resp.NewSimpleError("ERR " + err.Error()))
+		return
+	}
+
+	ctx.Reply(resp.NewSimpleString("Background append only file rewriting started"))
+}
+
+func LastSave(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(int(ctx.Persistence.LastSave().Unix())))
+}
+
 func ReplConf(ctx *server.Context) {
+	if len(ctx.Args) < 2 || len(ctx.Args)%2 != 0 {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR syntax error"))
+		}
+
+		return
+	}
+
+	for i := 0; i < len(ctx.Args); i += 2 {
+		option := strings.ToLower(ctx.Args[i])
+		value := ctx.Args[i+1]
+
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+
+			if err != nil {
+				if !ctx.FromMaster {
+					ctx.Reply(resp.NewSimpleError("ERR value is not an integer or out of range"))
+				}
+
+				return
+			}
+
+			ctx.Replcation.SetListeningPort(ctx.Addr(), port)
+
+		case "capa":
+			ctx.Replcation.SetCapa(ctx.Addr(), value)
+
+		case "ack":
+			// ACKs are sent by replicas and don't get a reply.
+			offset, err := strconv.Atoi(value)
+
+			if err == nil {
+				ctx.Replcation.Ack(ctx.Addr(), offset)
+			}
+
+			return
+
+		case "getack":
+			// GETACK is sent by the master to ask for the replication offset.
+			if !ctx.FromMaster {
+				return
+			}
+
+			ctx.Config.Mu.RLock()
+			offset := ctx.Config.Replication.MasterReplOffset
+			ctx.Config.Mu.RUnlock()
+
+			ack := resp.NewArray(
+				resp.NewBulkString("REPLCONF"),
+				resp.NewBulkString("ACK"),
+				resp.NewBulkString(strconv.Itoa(offset)),
+			)
+
+			ctx.Reply(ack)
+
+			return
+
+		default:
+			if !ctx.FromMaster {
+				msg := fmt.Sprintf("ERR Unrecognized REPLCONF option: %v", ctx.Args[i])
+				ctx.Reply(resp.NewSimpleError(msg))
+			}
+
+			return
+		}
+	}
+
 	if ctx.FromMaster {
 		return
 	}
 
-	// TODO: handle REPLCONF arguments
 	ctx.Reply(resp.NewSimpleString("OK"))
 }
 
-func PSync(ctx *server.Context) {
+func ReplicaOf(ctx *server.Context) {
 	if ctx.FromMaster {
 		return
 	}
 
-	ctx.Config.Mu.RLock()
+	if len(ctx.Args) != 2 {
+		msg := fmt.Sprintf("ERR wrong number of arguments for '%v' command", strings.ToLower(ctx.Command))
+		ctx.Reply(resp.NewSimpleError(msg))
+		return
+	}
 
-	replId := ctx.Config.Replication.MasterReplID
-	replOffset := ctx.Config.Replication.MasterReplOffset
+	if strings.EqualFold(ctx.Args[0], "no") && strings.EqualFold(ctx.Args[1], "one") {
+		ctx.Replcation.ReplicaOfNoOne()
+		ctx.Reply(resp.NewSimpleString("OK"))
+		return
+	}
 
+	host := ctx.Args[0]
+	port, err := strconv.ParseUint(ctx.Args[1], 10, 16)
+
+	if err != nil {
+		ctx.Reply(resp.NewSimpleError("ERR Invalid master port"))
+		return
+	}
+
+	ctx.Config.Mu.RLock()
+	r := ctx.Config.Replication
+	alreadyConnected := r.Role == config.RoleModeSlave && r.MasterHost == host && r.MasterPort == port
 	ctx.Config.Mu.RUnlock()
 
-	result := fmt.Sprintf("FULLRESYNC %v %v", replId, replOffset)
+	if alreadyConnected {
+		ctx.Reply(resp.NewSimpleString("OK Already connected to specified master"))
+		return
+	}
+
+	ctx.Replcation.ReplicaOf(host, port)
+	ctx.Reply(resp.NewSimpleString("OK"))
+}
+
+func Wait(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
 
-	ctx.Reply(resp.NewSimpleString(result))
+	if len(ctx.Args) != 2 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'wait' command"))
+		return
+	}
+
+	numReplicas, err := strconv.Atoi(ctx.Args[0])
 
-	// TODO: export a real RDB file once RDB is implemented into the server.
-	// For now, we'll just send an empty RDB file.
-	content, err := os.ReadFile("./empty.rdb")
 	if err != nil {
-		fmt.Println("Error reading RDB file:", err.Error())
-		ctx.Reply(resp.NewSimpleError("ERR internal error"))
+		ctx.Reply(resp.NewSimpleError("ERR value is not an integer or out of range"))
 		return
 	}
 
-	ctx.Reply(rdb.NewRDB(content))
+	timeout, err := strconv.Atoi(ctx.Args[1])
 
-	ctx.Replcation.AddReplica(ctx.Conn)
+	if err != nil || timeout < 0 {
+		ctx.Reply(resp.NewSimpleError("ERR timeout is not an integer or out of range"))
+		return
+	}
+
+	ctx.Config.Mu.RLock()
+	isReplica := ctx.Config.Replication.Role == config.RoleModeSlave
+	ctx.Config.Mu.RUnlock()
+
+	if isReplica {
+		ctx.Reply(resp.NewSimpleError("ERR WAIT cannot be used with replica instances."))
+		return
+	}
+
+	acked := ctx.Replcation.Wait(numReplicas, time.Duration(timeout)*time.Millisecond)
+
+	ctx.Reply(resp.NewInteger(acked))
+}
+
+func PSync(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 2 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'psync' command"))
+		return
+	}
+
+	replId := ctx.Args[0]
+	offset, err := strconv.Atoi(ctx.Args[1])
+
+	if err != nil {
+		offset = -1
+	}
+
+	if err := ctx.Replcation.Sync(ctx.Conn, replId, offset); err != nil {
+		fmt.Println("Failed to sync with replica:", err.Error())
+	}
 }
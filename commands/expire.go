@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a7medev/goredis/resp"
+	"github.com/a7medev/goredis/server"
+	"github.com/a7medev/goredis/storage"
+)
+
+// Expire handles EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, which set the expiry of a key
+// as a relative or a Unix time in seconds or milliseconds.
+func Expire(ctx *server.Context) {
+	name := strings.ToLower(ctx.Command)
+
+	if len(ctx.Args) < 2 {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for '" + name + "' command"))
+		}
+
+		return
+	}
+
+	key := ctx.Args[0]
+	t, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+
+	if err != nil {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR value is not an integer or out of range"))
+		}
+
+		return
+	}
+
+	cond, errMsg := parseExpireCondition(ctx.Args[2:])
+
+	if errMsg != "" {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError(errMsg))
+		}
+
+		return
+	}
+
+	millis, ok := expiryMillis(ctx.Command, t)
+
+	if !ok {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR invalid expire time in '" + name + "' command"))
+		}
+
+		return
+	}
+
+	expiry := storage.NewUnixMilliExpiry(millis)
+	isSet, deleted := ctx.DB.Expire(key, expiry, cond)
+
+	if deleted {
+		ctx.Propagate("DEL", key)
+	} else if isSet {
+		// The expiry is propagated as a Unix time, so replicas and the AOF don't extend it.
+		ctx.Propagate("PEXPIREAT", key, strconv.FormatInt(millis, 10))
+	}
+
+	if ctx.FromMaster {
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(boolToInt(isSet)))
+}
+
+// parseExpireCondition parses the NX, XX, GT and LT options of the EXPIRE commands.
+// It returns the error message if they're invalid.
+func parseExpireCondition(args []string) (storage.ExpireCondition, string) {
+	cond := storage.ExpireAlways
+
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			cond |= storage.ExpireNX
+		case "XX":
+			cond |= storage.ExpireXX
+		case "GT":
+			cond |= storage.ExpireGT
+		case "LT":
+			cond |= storage.ExpireLT
+		default:
+			return 0, "ERR Unsupported option " + arg
+		}
+	}
+
+	if cond&storage.ExpireNX != 0 && cond != storage.ExpireNX {
+		return 0, "ERR NX and XX, GT or LT options at the same time are not compatible"
+	}
+
+	if cond&storage.ExpireGT != 0 && cond&storage.ExpireLT != 0 {
+		return 0, "ERR GT and LT options at the same time are not compatible"
+	}
+
+	return cond, ""
+}
+
+// expiryMillis converts the time given to an EXPIRE command to a Unix time in milliseconds.
+// It returns false if it overflows.
+func expiryMillis(command string, t int64) (int64, bool) {
+	millis := t
+
+	if command == "EXPIRE" || command == "EXPIREAT" {
+		if t > math.MaxInt64/1000 || t < math.MinInt64/1000 {
+			return 0, false
+		}
+
+		millis = t * 1000
+	}
+
+	if command == "EXPIRE" || command == "PEXPIRE" {
+		now := time.Now().UnixMilli()
+
+		if millis > math.MaxInt64-now {
+			return 0, false
+		}
+
+		millis += now
+	}
+
+	return millis, true
+}
+
+// TTL handles TTL, PTTL, EXPIRETIME and PEXPIRETIME, which return the time to live of a key or its expiry
+// as a Unix time in seconds or milliseconds, -1 if the key has no expiry, or -2 if it doesn't exist.
+func TTL(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 1 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for '" + strings.ToLower(ctx.Command) + "' command"))
+		return
+	}
+
+	expiry, ok := ctx.DB.GetExpiry(ctx.Args[0])
+
+	if !ok {
+		ctx.Reply(resp.NewInteger(-2))
+		return
+	}
+
+	if !expiry.Expires {
+		ctx.Reply(resp.NewInteger(-1))
+		return
+	}
+
+	millis := expiry.Time.UnixMilli()
+	ttl := max(millis-time.Now().UnixMilli(), 0)
+
+	switch ctx.Command {
+	case "TTL":
+		ctx.Reply(resp.NewInteger(int(roundToSeconds(ttl))))
+	case "PTTL":
+		ctx.Reply(resp.NewInteger(int(ttl)))
+	case "EXPIRETIME":
+		ctx.Reply(resp.NewInteger(int(roundToSeconds(millis))))
+	default:
+		ctx.Reply(resp.NewInteger(int(millis)))
+	}
+}
+
+// roundToSeconds rounds milliseconds to the nearest second like Redis does.
+func roundToSeconds(millis int64) int64 {
+	return (millis + 500) / 1000
+}
+
+func Persist(ctx *server.Context) {
+	if len(ctx.Args) != 1 {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'persist' command"))
+		}
+
+		return
+	}
+
+	persisted := ctx.DB.Persist(ctx.Args[0])
+
+	if ctx.FromMaster {
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(boolToInt(persisted)))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
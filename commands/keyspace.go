@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/a7medev/goredis/glob"
+	"github.com/a7medev/goredis/resp"
+	"github.com/a7medev/goredis/server"
+)
+
+func Exists(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) == 0 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'exists' command"))
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(ctx.DB.Exists(ctx.Args...)))
+}
+
+func Type(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 1 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'type' command"))
+		return
+	}
+
+	ctx.Reply(resp.NewSimpleString(ctx.DB.Type(ctx.Args[0])))
+}
+
+// Rename handles RENAME and RENAMENX, which only renames the key if the new name doesn't exist.
+func Rename(ctx *server.Context) {
+	if len(ctx.Args) != 2 {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for '" + strings.ToLower(ctx.Command) + "' command"))
+		}
+
+		return
+	}
+
+	nx := ctx.Command == "RENAMENX"
+	renamed, exists := ctx.DB.Rename(ctx.Args[0], ctx.Args[1], nx)
+
+	if ctx.FromMaster {
+		return
+	}
+
+	if !exists {
+		ctx.Reply(resp.NewSimpleError("ERR no such key"))
+	} else if nx {
+		ctx.Reply(resp.NewInteger(boolToInt(renamed)))
+	} else {
+		ctx.Reply(resp.NewSimpleString("OK"))
+	}
+}
+
+func Copy(ctx *server.Context) {
+	if len(ctx.Args) < 2 {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'copy' command"))
+		}
+
+		return
+	}
+
+	src := ctx.Args[0]
+	dst := ctx.Args[1]
+	replace := false
+
+	for i := 2; i < len(ctx.Args); i++ {
+		switch strings.ToUpper(ctx.Args[i]) {
+		case "REPLACE":
+			replace = true
+
+		case "DB":
+			// There's a single database, so it's the only valid destination.
+			if i+1 >= len(ctx.Args) || ctx.Args[i+1] != "0" {
+				if !ctx.FromMaster {
+					ctx.Reply(resp.NewSimpleError("ERR DB index is out of range"))
+				}
+
+				return
+			}
+
+			i++
+
+		default:
+			if !ctx.FromMaster {
+				ctx.Reply(resp.NewSimpleError("ERR syntax error"))
+			}
+
+			return
+		}
+	}
+
+	if src == dst {
+		if !ctx.FromMaster {
+			ctx.Reply(resp.NewSimpleError("ERR source and destination objects are the same"))
+		}
+
+		return
+	}
+
+	copied := ctx.DB.Copy(src, dst, replace)
+
+	if ctx.FromMaster {
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(boolToInt(copied)))
+}
+
+func Touch(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) == 0 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'touch' command"))
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(ctx.DB.Touch(ctx.Args...)))
+}
+
+func RandomKey(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 0 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'randomkey' command"))
+		return
+	}
+
+	key, ok := ctx.DB.RandomKey()
+
+	if !ok {
+		ctx.Reply(resp.NewNullBulkString())
+		return
+	}
+
+	ctx.Reply(resp.NewBulkString(key))
+}
+
+func DBSize(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 0 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'dbsize' command"))
+		return
+	}
+
+	ctx.Reply(resp.NewInteger(ctx.DB.Size()))
+}
+
+func Keys(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) != 1 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'keys' command"))
+		return
+	}
+
+	result := resp.NewArray()
+
+	for _, key := range ctx.DB.Keys(ctx.Args[0]) {
+		result.Append(resp.NewBulkString(key))
+	}
+
+	ctx.Reply(result)
+}
+
+// Scan handles SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]. The options filter the keys
+// after they're read, so a call may return fewer keys than COUNT, or none, without the scan being done.
+func Scan(ctx *server.Context) {
+	if ctx.FromMaster {
+		return
+	}
+
+	if len(ctx.Args) < 1 {
+		ctx.Reply(resp.NewSimpleError("ERR wrong number of arguments for 'scan' command"))
+		return
+	}
+
+	cursor, err := strconv.ParseUint(ctx.Args[0], 10, 64)
+
+	if err != nil {
+		ctx.Reply(resp.NewSimpleError("ERR invalid cursor"))
+		return
+	}
+
+	pattern := ""
+	count := 10
+	typ := ""
+
+	for i := 1; i < len(ctx.Args); i += 2 {
+		if i+1 >= len(ctx.Args) {
+			ctx.Reply(resp.NewSimpleError("ERR syntax error"))
+			return
+		}
+
+		value := ctx.Args[i+1]
+
+		switch strings.ToUpper(ctx.Args[i]) {
+		case "MATCH":
+			pattern = value
+
+		case "COUNT":
+			count, err = strconv.Atoi(value)
+
+			if err != nil {
+				ctx.Reply(resp.NewSimpleError("ERR value is not an integer or out of range"))
+				return
+			}
+
+			if count < 1 {
+				ctx.Reply(resp.NewSimpleError("ERR syntax error"))
+				return
+			}
+
+		case "TYPE":
+			typ = strings.ToLower(value)
+
+		default:
+			ctx.Reply(resp.NewSimpleError("ERR syntax error"))
+			return
+		}
+	}
+
+	keys, next := ctx.DB.Scan(cursor, count)
+	result := resp.NewArray()
+
+	for _, key := range keys {
+		if pattern != "" && !glob.Match(pattern, key) {
+			continue
+		}
+
+		// The key may have been deleted since it was scanned, in which case its type is "none".
+		if typ != "" && ctx.DB.Type(key) != typ {
+			continue
+		}
+
+		result.Append(resp.NewBulkString(key))
+	}
+
+	ctx.Reply(resp.NewArray(resp.NewBulkString(strconv.FormatUint(next, 10)), result))
+}
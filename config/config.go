@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"math/rand"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -10,6 +12,8 @@ import (
 type Config struct {
 	Server      ServerConfig
 	Replication ReplicationConfig
+	Persistence PersistenceConfig
+	Memory      MemoryConfig
 	Mu          *sync.RWMutex
 }
 
@@ -17,6 +21,131 @@ type ServerConfig struct {
 	Port uint
 }
 
+type PersistenceConfig struct {
+	Dir              string
+	DBFilename       string
+	SavePoints       []SavePoint
+	AppendOnly       bool
+	AppendFilename   string
+	AppendFsync      FsyncPolicy
+	AOFLoadTruncated bool
+
+	// The AOF is rewritten automatically once it grows by AutoAOFRewritePercentage since the last rewrite,
+	// as long as it's at least AutoAOFRewriteMinSize bytes. A zero percentage disables automatic rewrites.
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+}
+
+// FsyncPolicy controls how often the append-only file is flushed to disk.
+type FsyncPolicy string
+
+const (
+	// Fsync after every write command
+	FsyncAlways FsyncPolicy = "always"
+	// Fsync at most once every second
+	FsyncEverySec FsyncPolicy = "everysec"
+	// Leave flushing to the operating system
+	FsyncNo FsyncPolicy = "no"
+)
+
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch policy := FsyncPolicy(strings.ToLower(s)); policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid appendfsync policy %q", s)
+	}
+}
+
+// SavePoint triggers a background save after Seconds have passed if at least Changes were made to the database.
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+// DefaultSavePoints are the save points used when none are configured.
+const DefaultSavePoints = "3600 1 300 100 60 10000"
+
+// ParseSavePoints parses save points in the format '<seconds> <changes> [<seconds> <changes> ...]'.
+// An empty string disables automatic saving.
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save points %q", s)
+	}
+
+	points := make([]SavePoint, 0, len(fields)/2)
+
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save seconds %q", fields[i])
+		}
+
+		changes, err := strconv.Atoi(fields[i+1])
+
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save changes %q", fields[i+1])
+		}
+
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+
+	return points, nil
+}
+
+func (c *PersistenceConfig) SavePointsString() string {
+	fields := make([]string, 0, len(c.SavePoints)*2)
+
+	for _, p := range c.SavePoints {
+		fields = append(fields, strconv.Itoa(p.Seconds), strconv.Itoa(p.Changes))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+type MemoryConfig struct {
+	// MaxMemory is the memory limit of the dataset in bytes, zero means no limit.
+	MaxMemory       int64
+	MaxMemoryPolicy MaxMemoryPolicy
+	// MaxMemorySamples is how many keys are sampled to pick each key to evict.
+	MaxMemorySamples int
+}
+
+// MaxMemoryPolicy controls which keys are evicted once the memory limit is reached.
+type MaxMemoryPolicy string
+
+const (
+	// Don't evict anything, writes that need more memory fail instead
+	MaxMemoryNoEviction MaxMemoryPolicy = "noeviction"
+	// Evict the least recently used keys
+	MaxMemoryAllKeysLRU MaxMemoryPolicy = "allkeys-lru"
+	// Evict the least recently used keys with an expiry
+	MaxMemoryVolatileLRU MaxMemoryPolicy = "volatile-lru"
+	// Evict the least frequently used keys
+	MaxMemoryAllKeysLFU MaxMemoryPolicy = "allkeys-lfu"
+	// Evict the least frequently used keys with an expiry
+	MaxMemoryVolatileLFU MaxMemoryPolicy = "volatile-lfu"
+	// Evict random keys
+	MaxMemoryAllKeysRandom MaxMemoryPolicy = "allkeys-random"
+	// Evict random keys with an expiry
+	MaxMemoryVolatileRandom MaxMemoryPolicy = "volatile-random"
+	// Evict the keys with the nearest expiry
+	MaxMemoryVolatileTTL MaxMemoryPolicy = "volatile-ttl"
+)
+
+func ParseMaxMemoryPolicy(s string) (MaxMemoryPolicy, error) {
+	switch policy := MaxMemoryPolicy(strings.ToLower(s)); policy {
+	case MaxMemoryNoEviction, MaxMemoryAllKeysLRU, MaxMemoryVolatileLRU, MaxMemoryAllKeysLFU, MaxMemoryVolatileLFU,
+		MaxMemoryAllKeysRandom, MaxMemoryVolatileRandom, MaxMemoryVolatileTTL:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid maxmemory policy %q", s)
+	}
+}
+
 type RoleMode string
 
 const (
@@ -30,47 +159,126 @@ type ReplicationConfig struct {
 	MasterPort       uint64
 	MasterReplID     string
 	MasterReplOffset int
+	MasterReplID2    string
+	SecondReplOffset int
 	ConnectedSlaves  uint
+	ReplBacklogSize  int64
+	ReplicaReadOnly  bool
+
+	// ReplPingReplicaPeriod is how often, in seconds, the master pings its replicas through the replication stream.
+	ReplPingReplicaPeriod int
+	// ReplTimeout is how long, in seconds, the master or a replica waits without hearing from the other side
+	// before dropping the link.
+	ReplTimeout int
+
+	// ReplDisklessSync streams the RDB of a full resynchronization straight to replicas that support it
+	// instead of saving it to disk first.
+	ReplDisklessSync bool
+
+	// ReplicaOutputBufferLimit bounds the replication stream buffered for a replica that can't keep up.
+	ReplicaOutputBufferLimit OutputBufferLimit
 }
 
-// entry converts a config entry to a string in the format used in the INFO command.
-func entry(name string, value any) string {
-	return fmt.Sprintf("%s:%v\n", name, value)
+// ClearReplID2 forgets the previous replication ID, so partial resynchronizations can't continue its history anymore.
+func (c *ReplicationConfig) ClearReplID2() {
+	c.MasterReplID2 = "0000000000000000000000000000000000000000"
+	c.SecondReplOffset = -1
 }
 
-func (c *ServerConfig) String() string {
-	b := strings.Builder{}
+// OutputBufferLimit disconnects a client once its output buffer reaches Hard bytes,
+// or stays above Soft bytes for SoftSeconds. A zero limit is disabled.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
 
-	b.WriteString("# Server\n")
-	b.WriteString(entry("tcp_port", c.Port))
-	b.WriteByte('\n')
+// ParseOutputBufferLimit parses a limit of the replica class in the format '<class> <hard> <soft> <soft seconds>'.
+func ParseOutputBufferLimit(s string) (OutputBufferLimit, error) {
+	fields := strings.Fields(s)
 
-	return b.String()
+	if len(fields) != 4 {
+		return OutputBufferLimit{}, fmt.Errorf("invalid client output buffer limit %q", s)
+	}
+
+	if class := strings.ToLower(fields[0]); class != "replica" && class != "slave" {
+		return OutputBufferLimit{}, fmt.Errorf("unsupported client output buffer limit class %q", fields[0])
+	}
+
+	hard, err := ParseMemory(fields[1])
+
+	if err != nil {
+		return OutputBufferLimit{}, err
+	}
+
+	soft, err := ParseMemory(fields[2])
+
+	if err != nil {
+		return OutputBufferLimit{}, err
+	}
+
+	seconds, err := strconv.Atoi(fields[3])
+
+	if err != nil || seconds < 0 {
+		return OutputBufferLimit{}, fmt.Errorf("invalid client output buffer limit seconds %q", fields[3])
+	}
+
+	return OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}, nil
 }
 
-func (c *ReplicationConfig) String() string {
-	b := strings.Builder{}
+func (l OutputBufferLimit) String() string {
+	return fmt.Sprintf("replica %d %d %d", l.Hard, l.Soft, l.SoftSeconds)
+}
 
-	b.WriteString("# Replication\n")
+// Entry converts a config entry to a string in the format used in the INFO command.
+func Entry(name string, value any) string {
+	return fmt.Sprintf("%s:%v\n", name, value)
+}
 
-	b.WriteString(entry("role", c.Role))
-	b.WriteString(entry("connected_slaves", c.ConnectedSlaves))
-	b.WriteString(entry("master_replid", c.MasterReplID))
-	b.WriteString(entry("master_repl_offset", c.MasterReplOffset))
+func (c *ServerConfig) String() string {
+	b := strings.Builder{}
 
+	b.WriteString("# Server\n")
+	b.WriteString(Entry("tcp_port", c.Port))
 	b.WriteByte('\n')
 
 	return b.String()
 }
 
 func NewConfig(port uint) *Config {
+	savePoints, _ := ParseSavePoints(DefaultSavePoints)
+
 	return &Config{
 		Mu:     new(sync.RWMutex),
 		Server: ServerConfig{Port: port},
+		Persistence: PersistenceConfig{
+			Dir:                      ".",
+			DBFilename:               "dump.rdb",
+			SavePoints:               savePoints,
+			AppendFilename:           "appendonly.aof",
+			AppendFsync:              FsyncEverySec,
+			AOFLoadTruncated:         true,
+			AutoAOFRewritePercentage: 100,
+			AutoAOFRewriteMinSize:    64 << 20,
+		},
+		Memory: MemoryConfig{
+			MaxMemoryPolicy:  MaxMemoryNoEviction,
+			MaxMemorySamples: 5,
+		},
 		Replication: ReplicationConfig{
 			Role:             RoleModeMaster,
 			MasterReplID:     "?",
 			MasterReplOffset: -1,
+			MasterReplID2:    "0000000000000000000000000000000000000000",
+			SecondReplOffset: -1,
+			ReplBacklogSize:  1 << 20,
+			ReplicaReadOnly:  true,
+
+			ReplPingReplicaPeriod: 10,
+			ReplTimeout:           60,
+
+			ReplDisklessSync:         true,
+			ReplicaOutputBufferLimit: OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60},
 		},
 	}
 }
@@ -87,3 +295,13 @@ func RandomID(length int) string {
 
 	return string(b)
 }
+
+// RDBPath returns the path of the RDB file used for loading and saving snapshots.
+func (c *PersistenceConfig) RDBPath() string {
+	return filepath.Join(c.Dir, c.DBFilename)
+}
+
+// AOFPath returns the path of the append-only file.
+func (c *PersistenceConfig) AOFPath() string {
+	return filepath.Join(c.Dir, c.AppendFilename)
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/a7medev/goredis/glob"
+)
+
+// param is a configuration parameter exposed through the CONFIG command.
+type param struct {
+	name string
+	get  func(c *Config) string
+}
+
+var params = []param{
+	{
+		name: "port",
+		get:  func(c *Config) string { return strconv.FormatUint(uint64(c.Server.Port), 10) },
+	},
+	{
+		name: "dir",
+		get:  func(c *Config) string { return c.Persistence.Dir },
+	},
+	{
+		name: "dbfilename",
+		get:  func(c *Config) string { return c.Persistence.DBFilename },
+	},
+	{
+		name: "save",
+		get:  func(c *Config) string { return c.Persistence.SavePointsString() },
+	},
+	{
+		name: "repl-backlog-size",
+		get:  func(c *Config) string { return strconv.FormatInt(c.Replication.ReplBacklogSize, 10) },
+	},
+	{
+		name: "replica-read-only",
+		get:  func(c *Config) string { return yesNo(c.Replication.ReplicaReadOnly) },
+	},
+	{
+		name: "repl-ping-replica-period",
+		get:  func(c *Config) string { return strconv.Itoa(c.Replication.ReplPingReplicaPeriod) },
+	},
+	{
+		name: "repl-timeout",
+		get:  func(c *Config) string { return strconv.Itoa(c.Replication.ReplTimeout) },
+	},
+	{
+		name: "repl-diskless-sync",
+		get:  func(c *Config) string { return yesNo(c.Replication.ReplDisklessSync) },
+	},
+	{
+		name: "client-output-buffer-limit",
+		get:  func(c *Config) string { return c.Replication.ReplicaOutputBufferLimit.String() },
+	},
+	{
+		name: "maxmemory",
+		get:  func(c *Config) string { return strconv.FormatInt(c.Memory.MaxMemory, 10) },
+	},
+	{
+		name: "maxmemory-policy",
+		get:  func(c *Config) string { return string(c.Memory.MaxMemoryPolicy) },
+	},
+	{
+		name: "maxmemory-samples",
+		get:  func(c *Config) string { return strconv.Itoa(c.Memory.MaxMemorySamples) },
+	},
+	{
+		name: "appendonly",
+		get:  func(c *Config) string { return yesNo(c.Persistence.AppendOnly) },
+	},
+	{
+		name: "appendfilename",
+		get:  func(c *Config) string { return c.Persistence.AppendFilename },
+	},
+	{
+		name: "appendfsync",
+		get:  func(c *Config) string { return string(c.Persistence.AppendFsync) },
+	},
+	{
+		name: "aof-load-truncated",
+		get:  func(c *Config) string { return yesNo(c.Persistence.AOFLoadTruncated) },
+	},
+	{
+		name: "auto-aof-rewrite-percentage",
+		get:  func(c *Config) string { return strconv.Itoa(c.Persistence.AutoAOFRewritePercentage) },
+	},
+	{
+		name: "auto-aof-rewrite-min-size",
+		get:  func(c *Config) string { return strconv.FormatInt(c.Persistence.AutoAOFRewriteMinSize, 10) },
+	},
+}
+
+// Get returns the values of the parameters whose names match the glob-style pattern, keyed by name.
+// The caller is expected to hold the config lock.
+func (c *Config) Get(pattern string) map[string]string {
+	pattern = strings.ToLower(pattern)
+	result := make(map[string]string)
+
+	for _, p := range params {
+		if glob.Match(pattern, p.name) {
+			result[p.name] = p.get(c)
+		}
+	}
+
+	return result
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// ParseYesNo parses a boolean configuration value written as 'yes' or 'no'.
+func ParseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("argument must be 'yes' or 'no', got %q", s)
+	}
+}
+
+// ParseMemory parses a memory size in bytes with an optional unit (k, kb, m, mb, g or gb) like '64mb'.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10},
+		{"mb", 1 << 20},
+		{"gb", 1 << 30},
+		{"k", 1000},
+		{"m", 1000 * 1000},
+		{"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	s = strings.ToLower(s)
+	multiplier := int64(1)
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.multiplier
+
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,114 @@
+package glob
+
+// Match reports whether s matches the glob-style pattern.
+// Unlike path.Match, '/' isn't special and a malformed pattern never fails, an unclosed '[' runs to the end of the pattern.
+func Match(pattern, s string) bool {
+	p, i := 0, 0
+
+	// The position after the last '*' and the character it was matched up to, used to backtrack on a mismatch.
+	star, starMatched := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+
+				if p == len(pattern) {
+					return true
+				}
+
+				star, starMatched = p, i
+
+				continue
+
+			case '?':
+				p++
+				i++
+
+				continue
+
+			case '[':
+				if matched, next := matchClass(pattern, p, s[i]); matched {
+					p = next
+					i++
+
+					continue
+				}
+
+			default:
+				c, next := pattern[p], p+1
+
+				if c == '\\' && next < len(pattern) {
+					c, next = pattern[next], next+1
+				}
+
+				if c == s[i] {
+					p = next
+					i++
+
+					continue
+				}
+			}
+		}
+
+		// Let the last '*' match one more character and retry the rest of the pattern.
+		if star < 0 {
+			return false
+		}
+
+		starMatched++
+		p, i = star, starMatched
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass matches c against the character class starting at pattern[start], which is '['.
+// It returns whether c matched and the position right after the class.
+func matchClass(pattern string, start int, c byte) (bool, int) {
+	p := start + 1
+	negated := false
+
+	if p < len(pattern) && pattern[p] == '^' {
+		negated = true
+		p++
+	}
+
+	matched := false
+
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			matched = matched || pattern[p+1] == c
+			p += 2
+
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			lo, hi := pattern[p], pattern[p+2]
+
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			matched = matched || (c >= lo && c <= hi)
+			p += 3
+
+		default:
+			matched = matched || pattern[p] == c
+			p++
+		}
+	}
+
+	if p < len(pattern) {
+		// Skip the closing ']'.
+		p++
+	}
+
+	return matched != negated, p
+}
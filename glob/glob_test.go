@@ -0,0 +1,65 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+
+		// Stars
+		{"*", "", true},
+		{"*", "anything", true},
+		{"a*", "a", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"a**c", "ac", true},
+		{"*a*b*", "xxaxxbxx", true},
+		{"*a*b", "ab_b", true},
+		{"*a*b", "ba", false},
+		{"user:*:name", "user:1:2:name", true},
+		{"a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+
+		// Question marks
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"?", "", false},
+		{"??", "ab", true},
+
+		// Classes
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hallo", true},
+		{"[\\]]", "]", true},
+		{"[\\-]", "-", true},
+		{"[abc", "b", true},
+		{"[abc", "d", false},
+
+		// Escapes
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h\\?llo", "h?llo", true},
+		{"h\\[a]llo", "h[a]llo", true},
+		{"a\\", "a\\", true}, // A trailing backslash matches itself
+
+		// Slashes aren't special, unlike in path.Match
+		{"a/*", "a/b/c", true},
+		{"*", "/", true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
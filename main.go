@@ -14,12 +14,84 @@ import (
 func main() {
 	var port uint
 	var replicaOf string
+	var dir string
+	var dbFilename string
+	var save string
+	var replBacklogSize string
+	var replicaReadOnly string
+	var replPingReplicaPeriod int
+	var replTimeout int
+	var clientOutputBufferLimit string
+	var replDisklessSync string
+	var appendOnly string
+	var appendFilename string
+	var appendFsync string
+	var aofLoadTruncated string
+	var autoAOFRewritePercentage int
+	var autoAOFRewriteMinSize string
+	var maxMemory string
+	var maxMemoryPolicy string
+	var maxMemorySamples int
 
 	flag.UintVar(&port, "port", 6379, "Port to listen on")
 	flag.StringVar(&replicaOf, "replicaof", "", "Master server to replicate from as 'host port'")
+	flag.StringVar(&replBacklogSize, "repl-backlog-size", "1mb", "Size of the replication backlog used for partial resynchronization")
+	flag.StringVar(&replicaReadOnly, "replica-read-only", "yes", "Reject write commands from clients when running as a replica ('yes' or 'no')")
+	flag.IntVar(&replPingReplicaPeriod, "repl-ping-replica-period", 10, "Interval in seconds between the PINGs a master sends to its replicas")
+	flag.IntVar(&replTimeout, "repl-timeout", 60, "Seconds without hearing from the master or a replica before the link is dropped")
+	flag.StringVar(&replDisklessSync, "repl-diskless-sync", "yes", "Stream the RDB of full resynchronizations straight to replicas instead of saving it to disk ('yes' or 'no')")
+	flag.StringVar(&clientOutputBufferLimit, "client-output-buffer-limit", "replica 256mb 64mb 60", "Output buffer limit of replicas as 'replica <hard> <soft> <soft seconds>'")
+	flag.StringVar(&dir, "dir", ".", "Directory where the RDB file is stored")
+	flag.StringVar(&dbFilename, "dbfilename", "dump.rdb", "Name of the RDB file")
+	flag.StringVar(&save, "save", config.DefaultSavePoints, "Save points as '<seconds> <changes> ...', an empty string disables automatic saving")
+	flag.StringVar(&appendOnly, "appendonly", "no", "Log every write command to the append-only file ('yes' or 'no')")
+	flag.StringVar(&appendFilename, "appendfilename", "appendonly.aof", "Name of the append-only file")
+	flag.StringVar(&appendFsync, "appendfsync", "everysec", "How often to fsync the append-only file ('always', 'everysec' or 'no')")
+	flag.StringVar(&aofLoadTruncated, "aof-load-truncated", "yes", "Load a truncated append-only file by dropping its incomplete tail ('yes' or 'no')")
+	flag.IntVar(&autoAOFRewritePercentage, "auto-aof-rewrite-percentage", 100, "Rewrite the append-only file when it grows by this percentage, 0 disables automatic rewrites")
+	flag.StringVar(&autoAOFRewriteMinSize, "auto-aof-rewrite-min-size", "64mb", "Minimum size of the append-only file to be rewritten automatically")
+	flag.StringVar(&maxMemory, "maxmemory", "0", "Memory limit of the dataset like '100mb', 0 means no limit")
+	flag.StringVar(&maxMemoryPolicy, "maxmemory-policy", "noeviction", "Which keys to evict once maxmemory is reached ('noeviction', 'allkeys-lru', 'volatile-lru', 'allkeys-lfu', 'volatile-lfu', 'allkeys-random', 'volatile-random' or 'volatile-ttl')")
+	flag.IntVar(&maxMemorySamples, "maxmemory-samples", 5, "Number of keys sampled to pick each key to evict")
 	flag.Parse()
 
 	cfg := config.NewConfig(port)
+	cfg.Persistence.Dir = dir
+	cfg.Persistence.DBFilename = dbFilename
+
+	savePoints, err := config.ParseSavePoints(save)
+
+	if err != nil {
+		log.Fatal("Invalid save argument ", err)
+	}
+
+	cfg.Persistence.SavePoints = savePoints
+
+	cfg.Persistence.AppendOnly, err = config.ParseYesNo(appendOnly)
+
+	if err != nil {
+		log.Fatal("Invalid appendonly argument ", err)
+	}
+
+	cfg.Persistence.AppendFilename = appendFilename
+	cfg.Persistence.AppendFsync, err = config.ParseFsyncPolicy(appendFsync)
+
+	if err != nil {
+		log.Fatal("Invalid appendfsync argument ", err)
+	}
+
+	cfg.Persistence.AOFLoadTruncated, err = config.ParseYesNo(aofLoadTruncated)
+
+	if err != nil {
+		log.Fatal("Invalid aof-load-truncated argument ", err)
+	}
+
+	cfg.Persistence.AutoAOFRewritePercentage = autoAOFRewritePercentage
+	cfg.Persistence.AutoAOFRewriteMinSize, err = config.ParseMemory(autoAOFRewriteMinSize)
+
+	if err != nil {
+		log.Fatal("Invalid auto-aof-rewrite-min-size argument ", err)
+	}
 
 	if replicaOf != "" {
 		masterHost, s, ok := strings.Cut(replicaOf, " ")
@@ -43,16 +115,96 @@ func main() {
 		cfg.Replication.MasterReplOffset = 0
 	}
 
+	cfg.Replication.ReplBacklogSize, err = config.ParseMemory(replBacklogSize)
+
+	if err != nil || cfg.Replication.ReplBacklogSize < 1 {
+		log.Fatal("Invalid repl-backlog-size argument ", err)
+	}
+
+	cfg.Replication.ReplicaReadOnly, err = config.ParseYesNo(replicaReadOnly)
+
+	if err != nil {
+		log.Fatal("Invalid replica-read-only argument ", err)
+	}
+
+	if replPingReplicaPeriod < 1 {
+		log.Fatal("Invalid repl-ping-replica-period argument ", replPingReplicaPeriod)
+	}
+
+	if replTimeout < 1 {
+		log.Fatal("Invalid repl-timeout argument ", replTimeout)
+	}
+
+	cfg.Replication.ReplPingReplicaPeriod = replPingReplicaPeriod
+	cfg.Replication.ReplTimeout = replTimeout
+	cfg.Replication.ReplDisklessSync, err = config.ParseYesNo(replDisklessSync)
+
+	if err != nil {
+		log.Fatal("Invalid repl-diskless-sync argument ", err)
+	}
+
+	cfg.Replication.ReplicaOutputBufferLimit, err = config.ParseOutputBufferLimit(clientOutputBufferLimit)
+
+	if err != nil {
+		log.Fatal("Invalid client-output-buffer-limit argument ", err)
+	}
+
+	cfg.Memory.MaxMemory, err = config.ParseMemory(maxMemory)
+
+	if err != nil {
+		log.Fatal("Invalid maxmemory argument ", err)
+	}
+
+	cfg.Memory.MaxMemoryPolicy, err = config.ParseMaxMemoryPolicy(maxMemoryPolicy)
+
+	if err != nil {
+		log.Fatal("Invalid maxmemory-policy argument ", err)
+	}
+
+	if maxMemorySamples < 1 {
+		log.Fatal("Invalid maxmemory-samples argument ", maxMemorySamples)
+	}
+
+	cfg.Memory.MaxMemorySamples = maxMemorySamples
+
 	s := server.NewServer(cfg)
 
 	s.AddCommand("PING", commands.Ping)
 	s.AddCommand("ECHO", commands.Echo)
-	s.AddCommand("SET", commands.Set).WithIsWrite(true)
+	s.AddCommand("SET", commands.Set).WithIsWrite(true).WithDenyOOM(true)
 	s.AddCommand("GET", commands.Get)
 	s.AddCommand("DEL", commands.Del).WithIsWrite(true)
+	s.AddCommand("EXPIRE", commands.Expire).WithIsWrite(true)
+	s.AddCommand("PEXPIRE", commands.Expire).WithIsWrite(true)
+	s.AddCommand("EXPIREAT", commands.Expire).WithIsWrite(true)
+	s.AddCommand("PEXPIREAT", commands.Expire).WithIsWrite(true)
+	s.AddCommand("PERSIST", commands.Persist).WithIsWrite(true)
+	s.AddCommand("TTL", commands.TTL)
+	s.AddCommand("PTTL", commands.TTL)
+	s.AddCommand("EXPIRETIME", commands.TTL)
+	s.AddCommand("PEXPIRETIME", commands.TTL)
+	s.AddCommand("EXISTS", commands.Exists)
+	s.AddCommand("TYPE", commands.Type)
+	s.AddCommand("RENAME", commands.Rename).WithIsWrite(true)
+	s.AddCommand("RENAMENX", commands.Rename).WithIsWrite(true)
+	s.AddCommand("COPY", commands.Copy).WithIsWrite(true).WithDenyOOM(true)
+	s.AddCommand("TOUCH", commands.Touch)
+	s.AddCommand("UNLINK", commands.Del).WithIsWrite(true)
+	s.AddCommand("RANDOMKEY", commands.RandomKey)
+	s.AddCommand("DBSIZE", commands.DBSize)
+	s.AddCommand("KEYS", commands.Keys)
+	s.AddCommand("SCAN", commands.Scan)
 	s.AddCommand("INFO", commands.Info)
+	s.AddCommand("CONFIG", commands.Config)
+	s.AddCommand("SAVE", commands.Save)
+	s.AddCommand("BGSAVE", commands.BgSave)
+	s.AddCommand("LASTSAVE", commands.LastSave)
+	s.AddCommand("BGREWRITEAOF", commands.BgRewriteAOF)
 	s.AddCommand("REPLCONF", commands.ReplConf)
 	s.AddCommand("PSYNC", commands.PSync)
+	s.AddCommand("WAIT", commands.Wait)
+	s.AddCommand("REPLICAOF", commands.ReplicaOf)
+	s.AddCommand("SLAVEOF", commands.ReplicaOf)
 
 	s.Start()
 }
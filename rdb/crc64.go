@@ -0,0 +1,19 @@
+package rdb
+
+import "hash/crc64"
+
+// crcTable is the table for the Jones polynomial used by Redis for RDB checksums (in reversed form).
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// checksum is a running CRC-64/Jones checksum as computed by Redis.
+// Unlike hash/crc64, Redis doesn't invert the checksum before and after each update, so we undo the inversion.
+type checksum uint64
+
+func (c *checksum) Write(p []byte) (int, error) {
+	*c = checksum(^crc64.Update(^uint64(*c), crcTable, p))
+	return len(p), nil
+}
+
+func (c checksum) Sum64() uint64 {
+	return uint64(c)
+}
@@ -0,0 +1,38 @@
+package rdb
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  uint64
+	}{
+		{name: "empty", input: "", want: 0},
+		// The check value of CRC-64/Jones as used by Redis, from the Redis source.
+		{name: "check value", input: "123456789", want: 0xe9c6d914c4b8d9ca},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c checksum
+			c.Write([]byte(tt.input))
+
+			if got := c.Sum64(); got != tt.want {
+				t.Errorf("checksum of %q = %x, want %x", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumIncremental(t *testing.T) {
+	var whole, parts checksum
+
+	whole.Write([]byte("123456789"))
+	parts.Write([]byte("1234"))
+	parts.Write([]byte("56789"))
+
+	if whole != parts {
+		t.Errorf("checksum written in parts = %x, want %x", parts.Sum64(), whole.Sum64())
+	}
+}
@@ -0,0 +1,380 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/a7medev/goredis/storage"
+)
+
+var ErrChecksum = errors.New("RDB checksum mismatch")
+
+// maxStringLength is the longest string Redis allows, a longer length can only come from a corrupt file.
+const maxStringLength = 512 << 20
+
+// readChunkSize is the longest string that's read at once, longer ones are read as they arrive so that
+// a corrupt length doesn't allocate more memory than the file actually holds.
+const readChunkSize = 64 << 10
+
+// Decoder reads an RDB file into a database.
+type Decoder struct {
+	r           *bufio.Reader
+	crc         checksum
+	skipExpired bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// WithSkipExpired makes the decoder drop keys which are already expired instead of loading them.
+func (d *Decoder) WithSkipExpired(skipExpired bool) *Decoder {
+	d.skipExpired = skipExpired
+	return d
+}
+
+// Decode reads all the keys in the RDB file and sets them in the database.
+func (d *Decoder) Decode(db *storage.Database) error {
+	magic, err := d.read(9)
+
+	if err != nil {
+		return err
+	}
+
+	if string(magic[:5]) != "REDIS" {
+		return errors.New("invalid RDB file signature")
+	}
+
+	version, err := strconv.Atoi(string(magic[5:]))
+
+	if err != nil || version < 1 || version > 12 {
+		return fmt.Errorf("unsupported RDB version %q", magic[5:])
+	}
+
+	expiry := storage.NeverExpires
+
+	for {
+		op, err := d.readByte()
+
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			if version < 5 {
+				return nil
+			}
+
+			return d.verifyChecksum()
+
+		case opSelectDB:
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+
+		case opResizeDB:
+			// Both the database and expires sizes are just hints.
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+
+		case opAux:
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+
+		case opExpireTimeMs:
+			b, err := d.read(8)
+
+			if err != nil {
+				return err
+			}
+
+			expiry = storage.NewUnixMilliExpiry(int64(binary.LittleEndian.Uint64(b)))
+
+		case opExpireTime:
+			b, err := d.read(4)
+
+			if err != nil {
+				return err
+			}
+
+			expiry = storage.NewUnixSecondExpiry(int64(binary.LittleEndian.Uint32(b)))
+
+		case opIdle:
+			if _, err := d.readLength(); err != nil {
+				return err
+			}
+
+		case opFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+
+		case opFunction2:
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+
+		case opSlotInfo:
+			for range 3 {
+				if _, err := d.readLength(); err != nil {
+					return err
+				}
+			}
+
+		case opModuleAux:
+			return errors.New("RDB module data is not supported")
+
+		case typeString:
+			key, err := d.readString()
+
+			if err != nil {
+				return err
+			}
+
+			value, err := d.readString()
+
+			if err != nil {
+				return err
+			}
+
+			if !d.skipExpired || !expiry.Expires || expiry.Time.After(time.Now()) {
+				db.Set(key, value, expiry, storage.SetDefault, false, false)
+			}
+
+			expiry = storage.NeverExpires
+
+		default:
+			return fmt.Errorf("unsupported RDB value type %d", op)
+		}
+	}
+}
+
+func (d *Decoder) verifyChecksum() error {
+	expected := d.crc.Sum64()
+
+	// The checksum itself isn't part of the checksum, so read it directly.
+	b := make([]byte, 8)
+
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return err
+	}
+
+	actual := binary.LittleEndian.Uint64(b)
+
+	// A zero checksum means that checksums were disabled when writing the file.
+	if actual != 0 && actual != expected {
+		return ErrChecksum
+	}
+
+	return nil
+}
+
+func (d *Decoder) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	d.crc.Write(b)
+
+	return b, nil
+}
+
+// readBytes reads a string of a length read from the file.
+func (d *Decoder) readBytes(length uint64) ([]byte, error) {
+	if length > maxStringLength {
+		return nil, fmt.Errorf("invalid RDB string length %d", length)
+	}
+
+	if length <= readChunkSize {
+		return d.read(int(length))
+	}
+
+	var buf bytes.Buffer
+
+	if _, err := io.CopyN(&buf, d.r, int64(length)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	d.crc.Write(buf.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.read(1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// readLengthOrEncoding reads a length, or the string encoding type if the length byte marks a special encoding.
+func (d *Decoder) readLengthOrEncoding() (length uint64, encoded bool, err error) {
+	b, err := d.readByte()
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case len6Bit:
+		return uint64(b & 0x3f), false, nil
+
+	case len14Bit:
+		next, err := d.readByte()
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+
+	case lenEnc:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	switch b {
+	case len32Bit:
+		p, err := d.read(4)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+
+	case len64Bit:
+		p, err := d.read(8)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		return binary.BigEndian.Uint64(p), false, nil
+	}
+
+	return 0, false, fmt.Errorf("invalid RDB length encoding %#x", b)
+}
+
+func (d *Decoder) readLength() (uint64, error) {
+	length, encoded, err := d.readLengthOrEncoding()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if encoded {
+		return 0, errors.New("unexpected RDB string encoding in place of a length")
+	}
+
+	return length, nil
+}
+
+func (d *Decoder) readString() (string, error) {
+	length, encoded, err := d.readLengthOrEncoding()
+
+	if err != nil {
+		return "", err
+	}
+
+	if !encoded {
+		b, err := d.readBytes(length)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(b), nil
+	}
+
+	switch length {
+	case encInt8:
+		b, err := d.read(1)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int8(b[0]))), nil
+
+	case encInt16:
+		b, err := d.read(2)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+
+	case encInt32:
+		b, err := d.read(4)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+
+	case encLZF:
+		compressedLen, err := d.readLength()
+
+		if err != nil {
+			return "", err
+		}
+
+		length, err := d.readLength()
+
+		if err != nil {
+			return "", err
+		}
+
+		if length > maxStringLength {
+			return "", fmt.Errorf("invalid RDB string length %d", length)
+		}
+
+		compressed, err := d.readBytes(compressedLen)
+
+		if err != nil {
+			return "", err
+		}
+
+		b, err := lzfDecompress(compressed, int(length))
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(b), nil
+	}
+
+	return "", fmt.Errorf("unsupported RDB string encoding %d", length)
+}
@@ -0,0 +1,155 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/a7medev/goredis/storage"
+)
+
+// Encoder writes a database to an RDB file.
+type Encoder struct {
+	w   *bufio.Writer
+	crc checksum
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes all the keys in the database along with their expiry to the RDB file.
+func (e *Encoder) Encode(db *storage.Database) error {
+	e.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+
+	e.writeAux("redis-ver", "7.2.0")
+	e.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	e.writeAux("aof-base", "0")
+
+	e.write([]byte{opSelectDB})
+	e.writeLength(0)
+
+	size, expires := 0, 0
+
+	db.Range(func(key, value string, expiry storage.Expiry) bool {
+		size++
+
+		if expiry.Expires {
+			expires++
+		}
+
+		return true
+	})
+
+	e.write([]byte{opResizeDB})
+	e.writeLength(uint64(size))
+	e.writeLength(uint64(expires))
+
+	db.Range(func(key, value string, expiry storage.Expiry) bool {
+		e.writeKeyValue(key, value, expiry)
+		return e.err == nil
+	})
+
+	e.write([]byte{opEOF})
+
+	if e.err != nil {
+		return e.err
+	}
+
+	// The checksum covers everything before it, including the EOF opcode.
+	sum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sum, e.crc.Sum64())
+
+	if _, err := e.w.Write(sum); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+func (e *Encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+
+	e.crc.Write(p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *Encoder) writeAux(key, value string) {
+	e.write([]byte{opAux})
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *Encoder) writeKeyValue(key, value string, expiry storage.Expiry) {
+	if expiry.Expires {
+		b := make([]byte, 9)
+		b[0] = opExpireTimeMs
+		binary.LittleEndian.PutUint64(b[1:], uint64(expiry.Time.UnixMilli()))
+
+		e.write(b)
+	}
+
+	e.write([]byte{typeString})
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *Encoder) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		e.write([]byte{byte(length)})
+	case length < 1<<14:
+		e.write([]byte{byte(length>>8) | len14Bit<<6, byte(length)})
+	case length <= 1<<32-1:
+		b := make([]byte, 5)
+		b[0] = len32Bit
+		binary.BigEndian.PutUint32(b[1:], uint32(length))
+
+		e.write(b)
+	default:
+		b := make([]byte, 9)
+		b[0] = len64Bit
+		binary.BigEndian.PutUint64(b[1:], length)
+
+		e.write(b)
+	}
+}
+
+// writeString writes a string, using the integer encoding when the string is the canonical form of a small integer.
+func (e *Encoder) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			e.writeInteger(n)
+			return
+		}
+	}
+
+	e.writeLength(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *Encoder) writeInteger(n int64) {
+	const prefix = lenEnc << 6
+
+	switch {
+	case n >= -1<<7 && n < 1<<7:
+		e.write([]byte{prefix | encInt8, byte(n)})
+	case n >= -1<<15 && n < 1<<15:
+		b := []byte{prefix | encInt16, 0, 0}
+		binary.LittleEndian.PutUint16(b[1:], uint16(n))
+
+		e.write(b)
+	default:
+		b := []byte{prefix | encInt32, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+
+		e.write(b)
+	}
+}
@@ -0,0 +1,68 @@
+package rdb
+
+import "errors"
+
+var errInvalidLZF = errors.New("invalid LZF compressed string")
+
+// lzfDecompress decompresses LZF compressed data as written by Redis into a buffer of the given length.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	// The longest back reference takes 3 bytes and expands to 264, so a longer length means the data is corrupt.
+	if length > len(in)*88 {
+		return nil, errInvalidLZF
+	}
+
+	out := make([]byte, 0, length)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl + 1 bytes
+			n := ctrl + 1
+
+			if i+n > len(in) {
+				return nil, errInvalidLZF
+			}
+
+			out = append(out, in[i:i+n]...)
+			i += n
+
+			continue
+		}
+
+		// Back reference
+		n := ctrl >> 5
+
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errInvalidLZF
+			}
+
+			n += int(in[i])
+			i++
+		}
+
+		if i >= len(in) {
+			return nil, errInvalidLZF
+		}
+
+		ref := len(out) - ((ctrl&0x1f)<<8 | int(in[i])) - 1
+		i++
+
+		if ref < 0 {
+			return nil, errInvalidLZF
+		}
+
+		// The reference may overlap with the bytes being written, so copy byte by byte.
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, errInvalidLZF
+	}
+
+	return out, nil
+}
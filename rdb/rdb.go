@@ -1,15 +1,35 @@
 package rdb
 
-import "fmt"
+// Version is the RDB format version written by the encoder.
+const Version = 11
 
-type RDB struct {
-	content []byte
-}
+// Opcodes and value types used in the RDB format.
+const (
+	opSlotInfo     byte = 0xf4
+	opFunction2    byte = 0xf5
+	opModuleAux    byte = 0xf7
+	opIdle         byte = 0xf8
+	opFreq         byte = 0xf9
+	opAux          byte = 0xfa
+	opResizeDB     byte = 0xfb
+	opExpireTimeMs byte = 0xfc
+	opExpireTime   byte = 0xfd
+	opSelectDB     byte = 0xfe
+	opEOF          byte = 0xff
 
-func NewRDB(content []byte) *RDB {
-	return &RDB{content: content}
-}
+	typeString byte = 0
+)
 
-func (r *RDB) Encode() string {
-	return fmt.Sprintf("$%v\r\n%v", len(r.content), string(r.content))
-}
+// Length encodings, the first two bits of a length byte.
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
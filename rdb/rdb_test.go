@@ -0,0 +1,307 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a7medev/goredis/storage"
+)
+
+type entry struct {
+	value string
+	// expiry is the Unix time in milliseconds the key expires at, zero if it never expires.
+	expiry int64
+}
+
+func TestRoundTrip(t *testing.T) {
+	future := time.Now().Add(time.Hour).UnixMilli()
+
+	tests := []struct {
+		name string
+		keys map[string]entry
+	}{
+		{name: "empty", keys: map[string]entry{}},
+		{
+			name: "strings",
+			keys: map[string]entry{
+				"key":        {value: "value"},
+				"empty":      {value: ""},
+				"":           {value: "empty key"},
+				"binary":     {value: "\x00\r\n\xff"},
+				"unicode":    {value: "héllo wörld"},
+				"length 63":  {value: strings.Repeat("a", 63)},
+				"length 64":  {value: strings.Repeat("b", 64)},
+				"length 16k": {value: strings.Repeat("c", 1<<14)},
+				"length 80k": {value: strings.Repeat("d", 80<<10)},
+			},
+		},
+		{
+			name: "integers",
+			keys: map[string]entry{
+				"0":           {value: "0"},
+				"int8":        {value: "-128"},
+				"int16":       {value: "32767"},
+				"int32":       {value: "-2147483648"},
+				"int32 max":   {value: "2147483647"},
+				"int64":       {value: "2147483648"},
+				"leading 0":   {value: "007"},
+				"plus":        {value: "+1"},
+				"minus zero":  {value: "-0"},
+				"12345":       {value: "54321"},
+				"with spaces": {value: " 1 "},
+			},
+		},
+		{
+			name: "expiry",
+			keys: map[string]entry{
+				"volatile":   {value: "v", expiry: future},
+				"persistent": {value: "p"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storage.NewDatabase()
+
+			for key, e := range tt.keys {
+				db.Set(key, e.value, expiryOf(e), storage.SetDefault, false, false)
+			}
+
+			var buf bytes.Buffer
+
+			if err := NewEncoder(&buf).Encode(db); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			decoded := storage.NewDatabase()
+
+			if err := NewDecoder(&buf).Decode(decoded); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			assertKeys(t, decoded, tt.keys)
+		})
+	}
+}
+
+// TestDecodeRedisDump decodes a file written by Redis 7.2.0, which holds AUX fields with integer encoded values.
+func TestDecodeRedisDump(t *testing.T) {
+	f, err := os.Open("testdata/empty-7.2.0.rdb")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	db := storage.NewDatabase()
+
+	if err := NewDecoder(f).Decode(db); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	assertKeys(t, db, map[string]entry{})
+}
+
+// The files below are laid out the way Redis writes them, see rdbFile.
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		skipExpired bool
+		want        map[string]entry
+	}{
+		{
+			name: "integer encoded strings",
+			body: "fe00 fb0300" +
+				"00 c07b c0ff" + // 123 => -1
+				"00 03693136 c13930" + // i16 => 12345
+				"00 03693332 c2d2029649", // i32 => 1234567890
+			want: map[string]entry{
+				"123": {value: "-1"},
+				"i16": {value: "12345"},
+				"i32": {value: "1234567890"},
+			},
+		},
+		{
+			name: "LZF compressed strings",
+			body: "fe00 fb0200" +
+				// A literal "a" followed by a back reference repeating it 39 times.
+				"00 03616161 c3 05 28 0061e01e00" +
+				// A literal "hello ", a back reference to it 11 bytes long, and a literal "!".
+				"00 0568656c6c6f c3 0c 12 0568656c6c6f20 e00205 0021",
+			want: map[string]entry{
+				"aaa":   {value: strings.Repeat("a", 40)},
+				"hello": {value: "hello hello hello!"},
+			},
+		},
+		{
+			name: "expiry",
+			body: "fe00 fb0202" +
+				"fc 00d8c32cbb030000 00 026d73 0176" + // EXPIRETIME_MS 4102444800000
+				"fd 005786f4 00 017301 76", // EXPIRETIME 4102444800
+			want: map[string]entry{
+				"ms": {value: "v", expiry: 4102444800000},
+				"s":  {value: "v", expiry: 4102444800000},
+			},
+		},
+		{
+			name:        "expired keys are skipped",
+			skipExpired: true,
+			body: "fe00 fb0201" +
+				"fc e803000000000000 00 0765787069726564 0176" + // Expired in 1970
+				"00 046c697665 0176",
+			want: map[string]entry{
+				"live": {value: "v"},
+			},
+		},
+		{
+			name: "expired keys are kept",
+			body: "fe00 fb0101" +
+				"fc e803000000000000 00 0765787069726564 0176",
+			want: map[string]entry{
+				"expired": {value: "v", expiry: 1000},
+			},
+		},
+		{
+			name: "aux fields, functions and hints are ignored",
+			body: "fa 0972656469732d766572 05372e322e34" + // redis-ver 7.2.4
+				"fa 0a72656469732d62697473 c040" + // redis-bits 64
+				"f5 0e23216c7561206e616d653d6c6962" + // FUNCTION2 "#!lua name=lib"
+				"fe00 fb0100" +
+				"f8 05" + // IDLE
+				"f9 07" + // FREQ
+				"00 036b6579 0576616c7565",
+			want: map[string]entry{
+				"key": {value: "value"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storage.NewDatabase()
+			err := NewDecoder(bytes.NewReader(rdbFile(t, tt.body))).WithSkipExpired(tt.skipExpired).Decode(db)
+
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			assertKeys(t, db, tt.want)
+		})
+	}
+}
+
+func TestDecodeChecksum(t *testing.T) {
+	file := rdbFile(t, "fe00 00 036b6579 0576616c7565")
+
+	// A zero checksum means the file was written with checksums disabled.
+	disabled := bytes.Clone(file)
+	clear(disabled[len(disabled)-8:])
+
+	if err := NewDecoder(bytes.NewReader(disabled)).Decode(storage.NewDatabase()); err != nil {
+		t.Errorf("Decode() with a disabled checksum error = %v", err)
+	}
+
+	corrupt := bytes.Clone(file)
+	corrupt[len(corrupt)-1] ^= 1
+
+	if err := NewDecoder(bytes.NewReader(corrupt)).Decode(storage.NewDatabase()); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Decode() with a wrong checksum error = %v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "bad signature", file: hex.EncodeToString([]byte("RADIS0011"))},
+		{name: "truncated", file: hex.EncodeToString([]byte("REDIS0011")) + "fe00 00 036b6579 05"},
+		{name: "huge 64 bit length", file: hex.EncodeToString([]byte("REDIS0011")) + "fe00 00 81ffffffffffffffff"},
+		{name: "32 bit length past the end", file: hex.EncodeToString([]byte("REDIS0011")) + "fe00 00 036b6579 8000ffffff 6161"},
+		{name: "huge LZF length", file: hex.EncodeToString([]byte("REDIS0011")) + "fe00 00 036b6579 c3 05 80ffffffff 0061e01e00"},
+		{name: "LZF reference before the start", file: hex.EncodeToString([]byte("REDIS0011")) + "fe00 00 036b6579 c3 02 03 e000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder(bytes.NewReader(unhex(t, tt.file))).Decode(storage.NewDatabase()); err == nil {
+				t.Error("Decode() error = nil, want an error")
+			}
+		})
+	}
+}
+
+// rdbFile builds an RDB file of version 11 from its body written in hex, which is everything between
+// the header and the EOF opcode. Spaces in the body are ignored.
+func rdbFile(t *testing.T, body string) []byte {
+	t.Helper()
+
+	file := append([]byte("REDIS0011"), unhex(t, body)...)
+	file = append(file, opEOF)
+
+	var c checksum
+	c.Write(file)
+
+	return binary.LittleEndian.AppendUint64(file, c.Sum64())
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func expiryOf(e entry) storage.Expiry {
+	if e.expiry == 0 {
+		return storage.NeverExpires
+	}
+
+	return storage.NewUnixMilliExpiry(e.expiry)
+}
+
+func assertKeys(t *testing.T, db *storage.Database, want map[string]entry) {
+	t.Helper()
+
+	got := make(map[string]entry)
+
+	db.Range(func(key, value string, expiry storage.Expiry) bool {
+		e := entry{value: value}
+
+		if expiry.Expires {
+			e.expiry = expiry.Time.UnixMilli()
+		}
+
+		got[key] = e
+
+		return true
+	})
+
+	if len(got) != len(want) {
+		t.Errorf("database has %d keys, want %d", len(got), len(want))
+	}
+
+	for key, w := range want {
+		g, ok := got[key]
+
+		if !ok {
+			t.Errorf("key %q is missing", key)
+		} else if g != w {
+			t.Errorf("key %q = %+v, want %+v", key, g, w)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package resp
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
+	"io"
 	"strconv"
 )
 
@@ -10,12 +12,62 @@ var ErrNull = errors.New("null")
 
 type Parser struct {
 	data *bufio.Reader
+
+	// raw holds a copy of the consumed bytes while recording.
+	raw *bytes.Buffer
 }
 
 func NewParser(data *bufio.Reader) *Parser {
 	return &Parser{data: data}
 }
 
+// StartRecording makes the parser keep a copy of the exact bytes it consumes, until Recorded is called.
+func (p *Parser) StartRecording() {
+	p.raw = new(bytes.Buffer)
+}
+
+// Recorded stops recording and returns the bytes consumed since StartRecording.
+func (p *Parser) Recorded() []byte {
+	if p.raw == nil {
+		return nil
+	}
+
+	raw := p.raw.Bytes()
+	p.raw = nil
+
+	return raw
+}
+
+func (p *Parser) record(b []byte) {
+	if p.raw != nil {
+		p.raw.Write(b)
+	}
+}
+
+func (p *Parser) readByte() (byte, error) {
+	b, err := p.data.ReadByte()
+
+	if err == nil {
+		p.record([]byte{b})
+	}
+
+	return b, err
+}
+
+// discard skips the next n bytes, which are expected to be present.
+func (p *Parser) discard(n int) error {
+	b, err := p.data.Peek(n)
+
+	if err != nil {
+		return err
+	}
+
+	p.record(b)
+	_, err = p.data.Discard(n)
+
+	return err
+}
+
 func (p *Parser) readUntilCRLF() (string, error) {
 	result, err := p.data.ReadString('\r')
 
@@ -23,8 +75,12 @@ func (p *Parser) readUntilCRLF() (string, error) {
 		return "", err
 	}
 
+	p.record([]byte(result))
+
 	// Skip the \n
-	p.data.Discard(1)
+	if err := p.discard(1); err != nil {
+		return "", err
+	}
 
 	end := len(result) - 1
 	return result[:end], nil
@@ -49,7 +105,7 @@ func (p *Parser) readInteger() (int, error) {
 }
 
 func (p *Parser) NextInteger() (int, error) {
-	t, err := p.data.ReadByte()
+	t, err := p.readByte()
 
 	if err != nil {
 		return 0, err
@@ -63,7 +119,7 @@ func (p *Parser) NextInteger() (int, error) {
 }
 
 func (p *Parser) NextSimpleString() (string, error) {
-	t, err := p.data.ReadByte()
+	t, err := p.readByte()
 
 	if err != nil {
 		return "", err
@@ -77,7 +133,7 @@ func (p *Parser) NextSimpleString() (string, error) {
 }
 
 func (p *Parser) NextBulkString() (string, error) {
-	t, err := p.data.ReadByte()
+	t, err := p.readByte()
 
 	if err != nil {
 		return "", err
@@ -98,24 +154,24 @@ func (p *Parser) NextBulkString() (string, error) {
 	}
 
 	result := make([]byte, length)
-	n, err := p.data.Read(result)
+	_, err = io.ReadFull(p.data, result)
 
 	if err != nil {
 		return "", err
 	}
 
-	if n != length {
-		return "", errors.New("failed to read bulk string")
-	}
+	p.record(result)
 
 	// Skip the \r\n
-	p.data.Discard(2)
+	if err := p.discard(2); err != nil {
+		return "", err
+	}
 
 	return string(result), nil
 }
 
 func (p *Parser) NextArrayLength() (int, error) {
-	t, err := p.data.ReadByte()
+	t, err := p.readByte()
 
 	if err != nil {
 		return 0, err
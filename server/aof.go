@@ -0,0 +1,357 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/a7medev/goredis/config"
+	"github.com/a7medev/goredis/storage"
+)
+
+// AOF is an append-only file logging every write command in RESP format.
+type AOF struct {
+	path  string
+	file  *os.File
+	fsync config.FsyncPolicy
+
+	mu        sync.Mutex
+	unsynced  bool
+	lastFsync time.Time
+
+	// pending holds the bytes that failed to be written, which are retried before anything else is written.
+	// lastWriteErr is the error of the last write, nil once the pending bytes are written.
+	pending      []byte
+	lastWriteErr error
+
+	// size is the current size of the file and baseSize is its size after the last rewrite (or on startup).
+	size     int64
+	baseSize int64
+
+	// rewriteBuf collects the commands written while the file is being rewritten, it's nil otherwise.
+	rewriteBuf *bytes.Buffer
+}
+
+func OpenAOF(path string, fsync config.FsyncPolicy) (*AOF, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	a := &AOF{
+		path:      path,
+		file:      f,
+		fsync:     fsync,
+		lastFsync: time.Now(),
+		size:      stat.Size(),
+		baseSize:  stat.Size(),
+	}
+
+	return a, nil
+}
+
+// Write appends an encoded command to the file, flushing it to disk right away if the fsync policy is always.
+// If the command can't be written, it's kept and retried along with the following ones.
+func (a *AOF) Write(msg string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.WriteString(msg)
+	}
+
+	a.pending = append(a.pending, msg...)
+
+	return a.writePending()
+}
+
+// writePending writes the pending bytes to the file, it must be called while holding the lock.
+// Whatever was written is kept in the file, so a partially written command is completed by the next try.
+func (a *AOF) writePending() error {
+	n, err := a.file.Write(a.pending)
+	a.size += int64(n)
+	a.pending = a.pending[n:]
+
+	if len(a.pending) == 0 {
+		a.pending = nil
+	}
+
+	if err == nil {
+		if a.fsync == config.FsyncAlways {
+			err = a.file.Sync()
+			a.lastFsync = time.Now()
+		} else {
+			a.unsynced = true
+		}
+	}
+
+	a.lastWriteErr = err
+
+	return err
+}
+
+// writeErr returns the error of the last write, which is nil unless some commands are still waiting to be written.
+func (a *AOF) writeErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.lastWriteErr
+}
+
+// cron retries writing the commands that failed to be written,
+// then flushes the file to disk once every second if the fsync policy is everysec.
+func (a *AOF) cron() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending != nil {
+		if err := a.writePending(); err != nil {
+			fmt.Println("Failed to write to the append-only file:", err)
+			return
+		}
+
+		fmt.Println("Writing to the append-only file works again")
+	}
+
+	if a.fsync != config.FsyncEverySec || !a.unsynced || time.Since(a.lastFsync) < time.Second {
+		return
+	}
+
+	if err := a.file.Sync(); err != nil {
+		fmt.Println("Failed to fsync the append-only file:", err)
+		return
+	}
+
+	a.unsynced = false
+	a.lastFsync = time.Now()
+}
+
+func (a *AOF) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Close()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// loadAOF replays the commands in the append-only file at path.
+// If the file ends with an incomplete command (e.g. after a crash), the incomplete command is removed from
+// the file when loadTruncated is true, otherwise an error is returned.
+func loadAOF(path string, loadTruncated bool, replay func(cmd string, args []string)) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: f}
+	buf := bufio.NewReader(counter)
+
+	// valid is the offset right after the last complete command.
+	var valid int64
+
+	for {
+		cmd, args, _, err := parseCommand(buf)
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("bad file format reading the append-only file at offset %v: %w", valid, err)
+			}
+
+			break
+		}
+
+		replay(cmd, args)
+
+		valid = counter.n - int64(buf.Buffered())
+	}
+
+	if valid == stat.Size() {
+		return nil
+	}
+
+	if !loadTruncated {
+		return fmt.Errorf("the append-only file is truncated at offset %v", valid)
+	}
+
+	fmt.Printf("The append-only file is truncated, dropping the last %v bytes\n", stat.Size()-valid)
+
+	return os.Truncate(path, valid)
+}
+
+// startRewrite starts collecting the commands written from now on until the rewrite is finished.
+func (a *AOF) startRewrite() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewriteBuf = new(bytes.Buffer)
+}
+
+// finishRewrite appends the commands written during the rewrite to the rewritten file f,
+// then atomically replaces the current file with it.
+func (a *AOF) finishRewrite(f *os.File) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	buf := a.rewriteBuf
+	a.rewriteBuf = nil
+
+	_, err := f.Write(buf.Bytes())
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := os.Rename(f.Name(), a.path); err != nil {
+		f.Close()
+		return err
+	}
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	// The rewritten file was opened for writing at its end, so it can be appended to right away.
+	a.file.Close()
+	a.file = f
+	a.size = stat.Size()
+	a.baseSize = stat.Size()
+	a.unsynced = false
+	a.lastFsync = time.Now()
+
+	// The commands that failed to be written to the old file are in the rewritten one.
+	a.pending = nil
+	a.lastWriteErr = nil
+
+	return nil
+}
+
+// abortRewrite stops collecting the commands written during a failed rewrite.
+func (a *AOF) abortRewrite() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewriteBuf = nil
+}
+
+// shouldRewrite reports whether the file has grown enough since the last rewrite to be rewritten automatically.
+func (a *AOF) shouldRewrite(percentage int, minSize int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if percentage <= 0 || a.rewriteBuf != nil || a.size < minSize {
+		return false
+	}
+
+	base := max(a.baseSize, 1)
+
+	return (a.size-base)*100/base >= int64(percentage)
+}
+
+// writeAOFSnapshot writes the database to an append-only file as one SET per key, then renames it to path.
+func writeAOFSnapshot(path string, db *storage.Database) error {
+	f, err := createAOFSnapshot(path, db)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	err = f.Sync()
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// createAOFSnapshot writes the database to a temporary file next to path as one SET per live key,
+// using an absolute expiry for volatile keys. The returned file is positioned at its end.
+func createAOFSnapshot(path string, db *storage.Database) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "temp-rewriteaof-*.aof")
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	now := time.Now()
+
+	db.Range(func(key, value string, expiry storage.Expiry) bool {
+		// Keys that already expired would only be deleted again on load.
+		if expiry.Expires && !expiry.Time.After(now) {
+			return true
+		}
+
+		cmd := createCommand("SET", key, value)
+
+		if expiry.Expires {
+			cmd = createCommand("SET", key, value, "PXAT", fmt.Sprint(expiry.Time.UnixMilli()))
+		}
+
+		_, err = w.WriteString(cmd.Encode())
+
+		return err == nil
+	})
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	return f, nil
+}
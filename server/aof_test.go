@@ -0,0 +1,88 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadAOF(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nvalue\r\n"
+	del := "*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+
+	tests := []struct {
+		name          string
+		content       string
+		loadTruncated bool
+		// replayed are the commands replayed, written as their name and arguments separated by spaces.
+		replayed []string
+		// size is the size of the file after loading it.
+		size    int
+		wantErr bool
+	}{
+		{name: "empty", content: "", loadTruncated: true, replayed: nil, size: 0},
+		{name: "complete", content: set + del, loadTruncated: true, replayed: []string{"SET k value", "DEL k"}, size: len(set + del)},
+
+		{name: "partial array length", content: set + "*2", loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "partial array length line", content: set + "*2\r", loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "partial bulk length", content: set + "*2\r\n$3\r\nDEL\r\n$", loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "partial bulk length line", content: set + "*2\r\n$3\r\nDEL\r\n$1", loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "partial bulk body", content: set + "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nval", loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "missing final CRLF", content: set + strings.TrimSuffix(del, "\r\n"), loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "missing final LF", content: set + strings.TrimSuffix(del, "\n"), loadTruncated: true, replayed: []string{"SET k value"}, size: len(set)},
+		{name: "only a partial command", content: "*2\r\n$3\r\nDEL", loadTruncated: true, replayed: nil, size: 0},
+
+		{
+			name:          "truncated without aof-load-truncated",
+			content:       set + "*2\r\n$3\r\nDEL\r\n$1",
+			loadTruncated: false,
+			replayed:      []string{"SET k value"},
+			size:          len(set + "*2\r\n$3\r\nDEL\r\n$1"),
+			wantErr:       true,
+		},
+		{
+			name:          "bad format",
+			content:       set + "+OK\r\n" + del,
+			loadTruncated: true,
+			replayed:      []string{"SET k value"},
+			size:          len(set + "+OK\r\n" + del),
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var replayed []string
+
+			err := loadAOF(path, tt.loadTruncated, func(cmd string, args []string) {
+				replayed = append(replayed, strings.Join(append([]string{cmd}, args...), " "))
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadAOF() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if !slices.Equal(replayed, tt.replayed) {
+				t.Errorf("loadAOF() replayed %q, want %q", replayed, tt.replayed)
+			}
+
+			content, err := os.ReadFile(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(content) != tt.size || string(content) != tt.content[:tt.size] {
+				t.Errorf("file after loading = %q, want %q", content, tt.content[:tt.size])
+			}
+		})
+	}
+}
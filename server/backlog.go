@@ -0,0 +1,68 @@
+package server
+
+// Backlog is a fixed-size circular buffer holding the most recent bytes of the replication stream,
+// which allows replicas that were briefly disconnected to continue from where they stopped.
+type Backlog struct {
+	buf []byte
+	// idx is the position in buf where the next byte will be written.
+	idx int
+	// histlen is the number of valid bytes in buf.
+	histlen int
+	// end is the replication offset of the last byte written to the backlog.
+	end int
+}
+
+// NewBacklog creates a backlog of the given size, starting after the given replication offset.
+func NewBacklog(size int, offset int) *Backlog {
+	return &Backlog{buf: make([]byte, size), end: offset}
+}
+
+func (b *Backlog) Write(p []byte) {
+	b.end += len(p)
+
+	// Only the last len(buf) bytes can fit.
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		p = p[n:]
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+	}
+}
+
+// FirstByteOffset returns the replication offset of the oldest byte in the backlog.
+func (b *Backlog) FirstByteOffset() int {
+	return b.end - b.histlen + 1
+}
+
+// From returns the bytes of the replication stream starting at the given offset,
+// or false if the backlog doesn't have all of them anymore (or yet).
+func (b *Backlog) From(offset int) ([]byte, bool) {
+	if offset < b.FirstByteOffset() || offset > b.end+1 {
+		return nil, false
+	}
+
+	n := b.end - offset + 1
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	result := make([]byte, 0, n)
+
+	if start+n <= len(b.buf) {
+		return append(result, b.buf[start:start+n]...), true
+	}
+
+	result = append(result, b.buf[start:]...)
+	result = append(result, b.buf[:n-len(result)]...)
+
+	return result, true
+}
+
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+func (b *Backlog) HistLen() int {
+	return b.histlen
+}
@@ -0,0 +1,89 @@
+package server
+
+import "testing"
+
+func TestBacklogFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		start  int
+		writes []string
+		offset int
+		want   string
+		ok     bool
+	}{
+		{name: "empty, next offset", size: 8, writes: nil, offset: 1, want: "", ok: true},
+		{name: "empty, before the start", size: 8, writes: nil, offset: 0, ok: false},
+		{name: "empty, after the end", size: 8, writes: nil, offset: 2, ok: false},
+
+		{name: "all of it", size: 10, writes: []string{"hello"}, offset: 1, want: "hello", ok: true},
+		{name: "middle", size: 10, writes: []string{"hello"}, offset: 3, want: "llo", ok: true},
+		{name: "last byte", size: 10, writes: []string{"hello"}, offset: 5, want: "o", ok: true},
+		{name: "next offset", size: 10, writes: []string{"hello"}, offset: 6, want: "", ok: true},
+		{name: "past the next offset", size: 10, writes: []string{"hello"}, offset: 7, ok: false},
+
+		{name: "exactly full", size: 4, writes: []string{"abcd"}, offset: 1, want: "abcd", ok: true},
+		{name: "one past full", size: 4, writes: []string{"abcd", "e"}, offset: 2, want: "bcde", ok: true},
+		{name: "one past full, overwritten", size: 4, writes: []string{"abcd", "e"}, offset: 1, ok: false},
+
+		{name: "wraparound, oldest byte", size: 8, writes: []string{"abcde", "fghij"}, offset: 3, want: "cdefghij", ok: true},
+		{name: "wraparound, across the end", size: 8, writes: []string{"abcde", "fghij"}, offset: 5, want: "efghij", ok: true},
+		{name: "wraparound, after the end", size: 8, writes: []string{"abcde", "fghij"}, offset: 9, want: "ij", ok: true},
+		{name: "wraparound, overwritten", size: 8, writes: []string{"abcde", "fghij"}, offset: 2, ok: false},
+		{name: "wraparound, next offset", size: 8, writes: []string{"abcde", "fghij"}, offset: 11, want: "", ok: true},
+
+		{name: "many wraparounds", size: 5, writes: []string{"ab", "cd", "ef", "gh", "ij", "k"}, offset: 7, want: "ghijk", ok: true},
+
+		{name: "write longer than the buffer", size: 4, writes: []string{"abcdefgh"}, offset: 5, want: "efgh", ok: true},
+		{name: "write longer than the buffer, overwritten", size: 4, writes: []string{"abcdefgh"}, offset: 4, ok: false},
+		{name: "write longer than the buffer, then more", size: 4, writes: []string{"abcdefgh", "ij"}, offset: 7, want: "ghij", ok: true},
+		{name: "write longer than the buffer after others", size: 4, writes: []string{"ab", "cdefghi"}, offset: 6, want: "fghi", ok: true},
+
+		{name: "starting offset", size: 8, start: 100, writes: []string{"xyz"}, offset: 101, want: "xyz", ok: true},
+		{name: "starting offset, before it", size: 8, start: 100, writes: []string{"xyz"}, offset: 100, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBacklog(tt.size, tt.start)
+
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+
+			got, ok := b.From(tt.offset)
+
+			if ok != tt.ok || string(got) != tt.want {
+				t.Errorf("From(%d) = %q, %v, want %q, %v", tt.offset, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBacklogOffsets(t *testing.T) {
+	b := NewBacklog(8, 100)
+
+	if got := b.FirstByteOffset(); got != 101 {
+		t.Errorf("FirstByteOffset() of an empty backlog = %d, want 101", got)
+	}
+
+	b.Write([]byte("abcde"))
+
+	if got := b.FirstByteOffset(); got != 101 {
+		t.Errorf("FirstByteOffset() = %d, want 101", got)
+	}
+
+	if got := b.HistLen(); got != 5 {
+		t.Errorf("HistLen() = %d, want 5", got)
+	}
+
+	b.Write([]byte("fghijk"))
+
+	if got := b.FirstByteOffset(); got != 104 {
+		t.Errorf("FirstByteOffset() after wrapping around = %d, want 104", got)
+	}
+
+	if got := b.HistLen(); got != 8 {
+		t.Errorf("HistLen() after wrapping around = %d, want 8", got)
+	}
+}
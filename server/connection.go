@@ -3,6 +3,7 @@ package server
 import (
 	"bufio"
 	"net"
+	"strings"
 	"sync"
 
 	"github.com/a7medev/goredis/resp"
@@ -48,3 +49,46 @@ func (c *NetConn) Close() error {
 func (c *NetConn) Addr() string {
 	return c.conn.RemoteAddr().String()
 }
+
+// discardConn is a connection that discards all replies, used when replaying commands.
+type discardConn struct{}
+
+func (discardConn) Reply(reply resp.Encodable) error {
+	return nil
+}
+
+func (discardConn) Reader() *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(""))
+}
+
+func (discardConn) Close() error {
+	return nil
+}
+
+func (discardConn) Addr() string {
+	return "aof"
+}
+
+// bufferedConn holds back the replies sent through it until flush is called.
+type bufferedConn struct {
+	Conn
+
+	replies []resp.Encodable
+}
+
+func (c *bufferedConn) Reply(reply resp.Encodable) error {
+	c.replies = append(c.replies, reply)
+
+	return nil
+}
+
+// flush sends the held back replies, stopping at the first one that fails.
+func (c *bufferedConn) flush() {
+	for _, reply := range c.replies {
+		if err := c.Conn.Reply(reply); err != nil {
+			return
+		}
+	}
+
+	c.replies = nil
+}
@@ -0,0 +1,30 @@
+package server
+
+import "time"
+
+// cronInterval is how often the server runs its periodic background tasks.
+const cronInterval = 100 * time.Millisecond
+
+// activeExpireBudget is the time each run spends at most deleting expired keys, so it doesn't starve clients.
+const activeExpireBudget = cronInterval / 4
+
+// cron runs the periodic background tasks of the server until it exits.
+func (s *Server) cron() {
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.persistence.cron()
+		s.replication.cron()
+		s.activeExpire()
+	}
+}
+
+// activeExpire deletes expired keys in the background and propagates their deletion.
+func (s *Server) activeExpire() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.db.ActiveExpire(activeExpireBudget)
+	s.propagateExpired()
+}
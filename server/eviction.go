@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/a7medev/goredis/config"
+	"github.com/a7medev/goredis/storage"
+)
+
+// evictionPolicy is how keys are picked for eviction under a maxmemory policy.
+type evictionPolicy struct {
+	evictBy  storage.EvictBy
+	volatile bool
+}
+
+var evictionPolicies = map[config.MaxMemoryPolicy]evictionPolicy{
+	config.MaxMemoryAllKeysLRU:     {evictBy: storage.EvictLRU},
+	config.MaxMemoryVolatileLRU:    {evictBy: storage.EvictLRU, volatile: true},
+	config.MaxMemoryAllKeysLFU:     {evictBy: storage.EvictLFU},
+	config.MaxMemoryVolatileLFU:    {evictBy: storage.EvictLFU, volatile: true},
+	config.MaxMemoryAllKeysRandom:  {evictBy: storage.EvictRandom},
+	config.MaxMemoryVolatileRandom: {evictBy: storage.EvictRandom, volatile: true},
+	config.MaxMemoryVolatileTTL:    {evictBy: storage.EvictTTL, volatile: true},
+}
+
+// freeMemoryIfNeeded evicts keys according to the maxmemory policy until the used memory is under the limit,
+// propagating a DEL for each of them. It returns false if the memory can't be freed.
+// Replicas don't evict keys on their own, they follow the deletions of their master.
+// It must be called while holding the write lock.
+func (s *Server) freeMemoryIfNeeded() bool {
+	s.config.Mu.RLock()
+	maxMemory := s.config.Memory.MaxMemory
+	policy := s.config.Memory.MaxMemoryPolicy
+	samples := s.config.Memory.MaxMemorySamples
+	isSlave := s.config.Replication.Role == config.RoleModeSlave
+	s.config.Mu.RUnlock()
+
+	if maxMemory == 0 || isSlave {
+		return true
+	}
+
+	eviction, canEvict := evictionPolicies[policy]
+
+	for s.db.UsedMemory() > maxMemory {
+		if !canEvict {
+			return false
+		}
+
+		key, ok := s.db.Evict(eviction.evictBy, eviction.volatile, samples)
+
+		if !ok {
+			return false
+		}
+
+		s.propagate(createCommand("DEL", key).Encode())
+	}
+
+	return true
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a7medev/goredis/config"
+	"github.com/a7medev/goredis/rdb"
+	"github.com/a7medev/goredis/storage"
+)
+
+var (
+	ErrBgSaveInProgress     = errors.New("background save already in progress")
+	ErrAOFRewriteInProgress = errors.New("background append only file rewriting already in progress")
+)
+
+// bgSaveRetryDelay is how long to wait before retrying automatic saves after a failed background save.
+const bgSaveRetryDelay = 5 * time.Second
+
+// Persistence manages saving the database to disk and the state reported in the persistence section of INFO.
+type Persistence struct {
+	config *config.Config
+	db     *storage.Database
+
+	// writeMu is held while taking snapshots so they don't include half-propagated write commands.
+	writeMu *sync.Mutex
+
+	aof *AOF
+
+	mu               sync.Mutex
+	lastSave         time.Time
+	lastSaveDirty    int64
+	bgSaveInProgress bool
+	lastBgSaveOK     bool
+	lastBgSaveTry    time.Time
+	lastBgSaveTime   int
+
+	aofRewriteInProgress bool
+	lastAOFRewriteOK     bool
+}
+
+func NewPersistence(cfg *config.Config, db *storage.Database, writeMu *sync.Mutex) *Persistence {
+	return &Persistence{
+		config:           cfg,
+		db:               db,
+		writeMu:          writeMu,
+		lastAOFRewriteOK: true,
+		lastSave:         time.Now(),
+		lastBgSaveOK:     true,
+		lastBgSaveTime:   -1,
+	}
+}
+
+// load restores the database from the append-only file if it's enabled, otherwise from the RDB file.
+// When the append-only file is enabled but doesn't exist yet, it's created from the RDB file contents.
+func (p *Persistence) load(replay func(cmd string, args []string)) error {
+	p.config.Mu.RLock()
+	appendOnly := p.config.Persistence.AppendOnly
+	path := p.config.Persistence.AOFPath()
+	fsync := p.config.Persistence.AppendFsync
+	loadTruncated := p.config.Persistence.AOFLoadTruncated
+	p.config.Mu.RUnlock()
+
+	if !appendOnly {
+		return p.loadRDB()
+	}
+
+	_, err := os.Stat(path)
+
+	switch {
+	case err == nil:
+		if err := loadAOF(path, loadTruncated, replay); err != nil {
+			return err
+		}
+
+		fmt.Println("Loaded append-only file", path)
+
+	case errors.Is(err, fs.ErrNotExist):
+		if err := p.loadRDB(); err != nil {
+			return err
+		}
+
+		if err := writeAOFSnapshot(path, p.db); err != nil {
+			return err
+		}
+
+	default:
+		return err
+	}
+
+	p.mu.Lock()
+	p.lastSaveDirty = p.db.Dirty()
+	p.mu.Unlock()
+
+	aof, err := OpenAOF(path, fsync)
+
+	if err != nil {
+		return err
+	}
+
+	p.aof = aof
+
+	return nil
+}
+
+// feedAOF appends an encoded write command to the append-only file if it's enabled.
+func (p *Persistence) feedAOF(msg string) {
+	if p.aof == nil {
+		return
+	}
+
+	if err := p.aof.Write(msg); err != nil {
+		// Clients were promised every write is on disk before they're told it succeeded, which can't be kept anymore.
+		if p.aof.fsync == config.FsyncAlways {
+			log.Fatalln("Can't recover from an append-only file write error when the fsync policy is always:", err)
+		}
+
+		fmt.Println("Failed to write to the append-only file:", err)
+	}
+}
+
+// aofWriteErr returns the error of the last write to the append-only file, if it's enabled and the write failed.
+func (p *Persistence) aofWriteErr() error {
+	if p.aof == nil {
+		return nil
+	}
+
+	return p.aof.writeErr()
+}
+
+// loadRDB restores the database from the configured RDB file, skipping keys that already expired.
+// A missing RDB file isn't an error, the server just starts with an empty database.
+func (p *Persistence) loadRDB() error {
+	p.config.Mu.RLock()
+	path := p.config.Persistence.RDBPath()
+	p.config.Mu.RUnlock()
+
+	f, err := os.Open(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	err = rdb.NewDecoder(f).WithSkipExpired(true).Decode(p.db)
+
+	if err != nil {
+		return err
+	}
+
+	// Loading isn't a change that needs to be saved.
+	p.mu.Lock()
+	p.lastSaveDirty = p.db.Dirty()
+	p.mu.Unlock()
+
+	fmt.Println("Loaded RDB file", path)
+
+	return nil
+}
+
+// Save synchronously saves the database to the RDB file, blocking writes to the database until it's done.
+func (p *Persistence) Save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bgSaveInProgress {
+		return ErrBgSaveInProgress
+	}
+
+	dirty := p.db.Dirty()
+
+	if err := p.writeRDB(p.db); err != nil {
+		return err
+	}
+
+	p.lastSave = time.Now()
+	p.lastSaveDirty = dirty
+
+	return nil
+}
+
+// BgSave saves a point-in-time copy of the database to the RDB file in the background.
+func (p *Persistence) BgSave() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bgSaveInProgress {
+		return ErrBgSaveInProgress
+	}
+
+	p.bgSaveInProgress = true
+	p.lastBgSaveTry = time.Now()
+
+	p.writeMu.Lock()
+	dirty := p.db.Dirty()
+	snapshot := p.db.Clone()
+	p.writeMu.Unlock()
+
+	go func() {
+		start := time.Now()
+		err := p.writeRDB(snapshot)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.bgSaveInProgress = false
+		p.lastBgSaveOK = err == nil
+		p.lastBgSaveTime = int(time.Since(start).Seconds())
+
+		if err != nil {
+			fmt.Println("Background saving failed:", err)
+			return
+		}
+
+		p.lastSave = time.Now()
+		p.lastSaveDirty = dirty
+
+		fmt.Println("Background saving terminated with success")
+	}()
+
+	return nil
+}
+
+// BgRewriteAOF rewrites the append-only file in the background from a point-in-time copy of the database.
+// Commands written while rewriting are collected and appended to the new file before it replaces the old one.
+func (p *Persistence) BgRewriteAOF() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.aofRewriteInProgress {
+		return ErrAOFRewriteInProgress
+	}
+
+	p.config.Mu.RLock()
+	path := p.config.Persistence.AOFPath()
+	p.config.Mu.RUnlock()
+
+	p.aofRewriteInProgress = true
+
+	p.writeMu.Lock()
+
+	if p.aof != nil {
+		p.aof.startRewrite()
+	}
+
+	snapshot := p.db.Clone()
+
+	p.writeMu.Unlock()
+
+	go func() {
+		var err error
+
+		if p.aof == nil {
+			err = writeAOFSnapshot(path, snapshot)
+		} else {
+			var f *os.File
+			f, err = createAOFSnapshot(path, snapshot)
+
+			if err == nil {
+				err = p.aof.finishRewrite(f)
+			}
+
+			if err != nil {
+				p.aof.abortRewrite()
+
+				if f != nil {
+					os.Remove(f.Name())
+				}
+			}
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.aofRewriteInProgress = false
+		p.lastAOFRewriteOK = err == nil
+
+		if err != nil {
+			fmt.Println("Background append only file rewriting failed:", err)
+			return
+		}
+
+		fmt.Println("Background append only file rewriting terminated with success")
+	}()
+
+	return nil
+}
+
+// LastSave returns the time of the last successful save.
+func (p *Persistence) LastSave() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lastSave
+}
+
+// writeRDB writes the database to a temporary file and then renames it to the RDB file,
+// so the RDB file is never left partially written.
+func (p *Persistence) writeRDB(db *storage.Database) error {
+	p.config.Mu.RLock()
+	dir := p.config.Persistence.Dir
+	path := p.config.Persistence.RDBPath()
+	p.config.Mu.RUnlock()
+
+	f, err := os.CreateTemp(dir, "temp-*.rdb")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	err = rdb.NewEncoder(f).Encode(db)
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// cron starts a background save when any of the configured save points is reached
+// and flushes the append-only file according to its fsync policy.
+func (p *Persistence) cron() {
+	if p.aof != nil {
+		p.aof.cron()
+	}
+
+	p.config.Mu.RLock()
+	rewritePercentage := p.config.Persistence.AutoAOFRewritePercentage
+	rewriteMinSize := p.config.Persistence.AutoAOFRewriteMinSize
+	p.config.Mu.RUnlock()
+
+	if p.aof != nil && p.aof.shouldRewrite(rewritePercentage, rewriteMinSize) {
+		fmt.Println("Starting automatic rewriting of the append only file")
+
+		if err := p.BgRewriteAOF(); err != nil && err != ErrAOFRewriteInProgress {
+			fmt.Println("Failed to start append only file rewriting:", err)
+		}
+	}
+
+	p.config.Mu.RLock()
+	savePoints := p.config.Persistence.SavePoints
+	p.config.Mu.RUnlock()
+
+	p.mu.Lock()
+
+	changes := p.db.Dirty() - p.lastSaveDirty
+	sinceLastSave := time.Since(p.lastSave)
+	canRetry := p.lastBgSaveOK || time.Since(p.lastBgSaveTry) > bgSaveRetryDelay
+	inProgress := p.bgSaveInProgress
+
+	p.mu.Unlock()
+
+	if inProgress || !canRetry {
+		return
+	}
+
+	for _, sp := range savePoints {
+		if changes >= int64(sp.Changes) && sinceLastSave >= time.Duration(sp.Seconds)*time.Second {
+			fmt.Printf("%v changes in %v seconds. Saving...\n", sp.Changes, sp.Seconds)
+
+			if err := p.BgSave(); err != nil {
+				fmt.Println("Failed to start background saving:", err)
+			}
+
+			return
+		}
+	}
+}
+
+func (p *Persistence) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b := strings.Builder{}
+
+	b.WriteString("# Persistence\n")
+	b.WriteString(config.Entry("loading", 0))
+	b.WriteString(config.Entry("rdb_changes_since_last_save", p.db.Dirty()-p.lastSaveDirty))
+	b.WriteString(config.Entry("rdb_bgsave_in_progress", boolToInt(p.bgSaveInProgress)))
+	b.WriteString(config.Entry("rdb_last_save_time", p.lastSave.Unix()))
+	b.WriteString(config.Entry("rdb_last_bgsave_status", okErr(p.lastBgSaveOK)))
+	b.WriteString(config.Entry("rdb_last_bgsave_time_sec", p.lastBgSaveTime))
+	b.WriteString(config.Entry("aof_enabled", boolToInt(p.aof != nil)))
+	b.WriteString(config.Entry("aof_rewrite_in_progress", boolToInt(p.aofRewriteInProgress)))
+	b.WriteString(config.Entry("aof_last_bgrewrite_status", okErr(p.lastAOFRewriteOK)))
+
+	if p.aof != nil {
+		p.aof.mu.Lock()
+
+		b.WriteString(config.Entry("aof_last_write_status", okErr(p.aof.lastWriteErr == nil)))
+		b.WriteString(config.Entry("aof_current_size", p.aof.size))
+		b.WriteString(config.Entry("aof_base_size", p.aof.baseSize))
+
+		p.aof.mu.Unlock()
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func okErr(ok bool) string {
+	if ok {
+		return "ok"
+	}
+
+	return "err"
+}
@@ -1,51 +1,1055 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/a7medev/goredis/config"
+	"github.com/a7medev/goredis/rdb"
 	"github.com/a7medev/goredis/resp"
+	"github.com/a7medev/goredis/storage"
+)
+
+type ReplicaState string
+
+const (
+	// The replica is waiting for the RDB snapshot, commands sent to it are buffered meanwhile.
+	ReplicaStateWaitBgsave ReplicaState = "wait_bgsave"
+	// The replica received the RDB snapshot and commands are sent to it right away.
+	ReplicaStateOnline ReplicaState = "online"
+)
+
+const (
+	// ackInterval is how often a replica acknowledges its replication offset to the master.
+	ackInterval = time.Second
+
+	// rdbEOFMarkLength is the length of the mark that ends an RDB sent without its length.
+	rdbEOFMarkLength = 40
+
+	// dialTimeout is how long a replica waits to connect to its master.
+	dialTimeout = 5 * time.Second
+
+	// A replica waits between minReconnectBackoff and maxReconnectBackoff before reconnecting to its master,
+	// doubling the wait after each failed attempt.
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// MasterLinkState is the state of the link between a replica and its master.
+type MasterLinkState string
+
+const (
+	MasterLinkStateConnecting MasterLinkState = "connecting"
+	MasterLinkStateHandshake  MasterLinkState = "handshake"
+	MasterLinkStateSync       MasterLinkState = "sync"
+	MasterLinkStateConnected  MasterLinkState = "connected"
 )
 
 type Replica struct {
 	Conn
 	Offset int
 	mu     sync.Mutex
+
+	// ListeningPort is the port the replica announced with REPLCONF listening-port.
+	ListeningPort int
+	// capaEOF is whether the replica can receive an RDB delimited by an EOF marker instead of its length.
+	capaEOF bool
+
+	state   ReplicaState
+	lastAck time.Time
+
+	// output holds the part of the replication stream that wasn't written to the replica yet,
+	// it's drained by the replica's own writer once it's online so a slow replica doesn't block the others.
+	output      bytes.Buffer
+	outputReady *sync.Cond
+	closed      bool
+	// softLimitSince is when the output buffer went above the soft limit, zero if it's below it.
+	softLimitSince time.Time
 }
 
+// SetOffset records the replication offset acknowledged by the replica.
 func (r *Replica) SetOffset(offset int) {
 	r.mu.Lock()
 	r.Offset = offset
+	r.lastAck = time.Now()
 	r.mu.Unlock()
 }
 
+// ErrOutputBufferLimit is returned when a replica falls too far behind the replication stream.
+var ErrOutputBufferLimit = errors.New("output buffer limit reached")
+
+// send appends a message from the replication stream to the output buffer of the replica without blocking.
+// It fails if the buffer goes over the limit, in which case the replica should be disconnected.
+func (r *Replica) send(msg string, limit config.OutputBufferLimit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.output.WriteString(msg)
+	r.outputReady.Signal()
+
+	size := int64(r.output.Len())
+
+	if limit.Hard > 0 && size >= limit.Hard {
+		return ErrOutputBufferLimit
+	}
+
+	if limit.Soft == 0 || size < limit.Soft {
+		r.softLimitSince = time.Time{}
+		return nil
+	}
+
+	if r.softLimitSince.IsZero() {
+		r.softLimitSince = time.Now()
+	}
+
+	if time.Since(r.softLimitSince) >= time.Duration(limit.SoftSeconds)*time.Second {
+		return ErrOutputBufferLimit
+	}
+
+	return nil
+}
+
+// setOnline marks the replica as online once it has the snapshot and starts writing the replication stream to it,
+// starting with the messages buffered meanwhile. onError is called if writing fails.
+func (r *Replica) setOnline(onError func(replica *Replica, err error)) {
+	r.mu.Lock()
+	r.state = ReplicaStateOnline
+	r.mu.Unlock()
+
+	go r.writeOutput(onError)
+}
+
+// writeOutput writes the output buffer to the replica as it fills up until the replica is closed.
+func (r *Replica) writeOutput(onError func(replica *Replica, err error)) {
+	for {
+		r.mu.Lock()
+
+		for r.output.Len() == 0 && !r.closed {
+			r.outputReady.Wait()
+		}
+
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+
+		msg := bytes.Clone(r.output.Bytes())
+		r.output.Reset()
+
+		r.mu.Unlock()
+
+		if err := r.Reply(rawMessage(msg)); err != nil {
+			onError(r, err)
+			return
+		}
+	}
+}
+
+// close closes the connection to the replica and stops its writer.
+func (r *Replica) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.outputReady.Broadcast()
+	r.mu.Unlock()
+
+	r.Close()
+}
+
+// rawMessage is an already encoded RESP message.
+type rawMessage []byte
+
+func (m rawMessage) Encode() string {
+	return string(m)
+}
+
+// Replication keeps track of the replicas connected to the master.
 type Replication struct {
+	config *config.Config
+	db     *storage.Database
+
+	// writeMu is held while taking snapshots so they match the replication offset exactly.
+	writeMu *sync.Mutex
+
+	// roleMu serializes role changes.
+	roleMu sync.Mutex
+
+	mu       sync.Mutex
 	Replicas map[string]*Replica
+	backlog  *Backlog
+
+	// handshakes holds what connections that didn't send PSYNC yet announced with REPLCONF.
+	handshakes map[string]*handshake
+
+	// acked is closed and replaced whenever a replica acknowledges its offset.
+	acked chan struct{}
+
+	// runReplica keeps the server replicating from its master until the context is cancelled.
+	runReplica func(ctx context.Context)
+	// stopReplica stops the running replication from the master, it's nil when the server is a master.
+	stopReplica func()
+
+	// lastPing is when the master last pinged its replicas.
+	lastPing time.Time
+
+	// The state of the link with the master when the server is a replica.
+	masterConn    Conn
+	linkState     MasterLinkState
+	linkUp        bool
+	linkLastIO    time.Time
+	linkDownSince time.Time
 }
 
-func (r *Replication) AddReplica(conn Conn) {
-	r.Replicas[conn.Addr()] = &Replica{Conn: conn}
+func NewReplication(cfg *config.Config, db *storage.Database, writeMu *sync.Mutex, runReplica func(ctx context.Context)) *Replication {
+	cfg.Mu.RLock()
+	backlog := NewBacklog(int(cfg.Replication.ReplBacklogSize), max(cfg.Replication.MasterReplOffset, 0))
+	cfg.Mu.RUnlock()
+
+	return &Replication{
+		config:   cfg,
+		db:       db,
+		writeMu:  writeMu,
+		Replicas: make(map[string]*Replica),
+		backlog:  backlog,
+
+		handshakes: make(map[string]*handshake),
+		acked:      make(chan struct{}),
+		runReplica: runReplica,
+		linkState:  MasterLinkStateConnecting,
+	}
 }
 
-// startReplication connects to the master server and starts the replication process.
-func (s *Server) startReplication() {
-	s.config.Mu.Lock()
+// startReplica starts replicating from the configured master in the background.
+func (r *Replication) startReplica() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.runReplica(ctx)
+	}()
+
+	r.stopReplica = func() {
+		cancel()
+		<-done
+	}
+}
+
+// ReplicaOf makes the server a replica of the master at host:port, dropping its own replicas if it was a master.
+// The current replication ID and offset are kept so the new master can continue from them if possible.
+func (r *Replication) ReplicaOf(host string, port uint64) {
+	r.roleMu.Lock()
+	defer r.roleMu.Unlock()
+
+	if r.stopReplica != nil {
+		r.stopReplica()
+		r.stopReplica = nil
+	}
+
+	r.writeMu.Lock()
+
+	r.config.Mu.Lock()
+	r.config.Replication.Role = config.RoleModeSlave
+	r.config.Replication.MasterHost = host
+	r.config.Replication.MasterPort = port
+	r.config.Mu.Unlock()
+
+	r.db.SetReplicaMode(true)
+
+	r.mu.Lock()
+
+	// The replicas will have to sync with the data of the new master.
+	r.disconnectReplicas()
+
+	r.linkState = MasterLinkStateConnecting
+	r.linkDownSince = time.Now()
+
+	r.mu.Unlock()
+
+	r.writeMu.Unlock()
+
+	fmt.Printf("Replicating from master %v:%v\n", host, port)
+
+	r.startReplica()
+}
+
+// ReplicaOfNoOne promotes a replica to a master with a new replication ID.
+// The previous replication ID is kept as a secondary ID so the other replicas of the previous master,
+// and its own sub-replicas which are disconnected, can continue from the same offset.
+func (r *Replication) ReplicaOfNoOne() {
+	r.roleMu.Lock()
+	defer r.roleMu.Unlock()
+
+	if r.stopReplica == nil {
+		return
+	}
+
+	r.stopReplica()
+	r.stopReplica = nil
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.config.Mu.Lock()
+
+	c := &r.config.Replication
+
+	// A replica that never synced has nothing to continue from.
+	if c.MasterReplID != "?" {
+		c.MasterReplID2 = c.MasterReplID
+		c.SecondReplOffset = c.MasterReplOffset + 1
+	}
+
+	c.Role = config.RoleModeMaster
+	c.MasterHost = ""
+	c.MasterPort = 0
+	c.MasterReplID = config.RandomID(40)
+	c.MasterReplOffset = max(c.MasterReplOffset, 0)
+
+	r.db.SetReplicaMode(false)
+
+	replId := c.MasterReplID
+	offset := c.MasterReplOffset
+
+	r.config.Mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backlog.end != offset {
+		r.backlog = NewBacklog(r.backlog.Size(), offset)
+	}
+
+	// The sub-replicas are disconnected to learn about the new ID before anything is written with it,
+	// otherwise their offset would move past the secondary one while they still use the previous ID.
+	r.disconnectReplicas()
+
+	fmt.Println("Promoted to master with replication ID", replId)
+}
+
+// resetBacklog empties the backlog after a full sync with the master at the given offset,
+// disconnecting the sub-replicas as they have to sync with the new data.
+func (r *Replication) resetBacklog(offset int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.backlog = NewBacklog(r.backlog.Size(), offset)
+	r.disconnectReplicas()
+}
+
+// shiftReplID switches to the new replication ID of the master, keeping the current one as the secondary ID
+// so the sub-replicas can continue from their offset. They're disconnected to learn about the new ID.
+// It must be called while holding the write lock.
+func (r *Replication) shiftReplID(replId string) {
+	r.config.Mu.Lock()
+	c := &r.config.Replication
+
+	if c.MasterReplID == replId {
+		r.config.Mu.Unlock()
+		return
+	}
+
+	c.MasterReplID2 = c.MasterReplID
+	c.SecondReplOffset = c.MasterReplOffset + 1
+	c.MasterReplID = replId
+
+	r.config.Mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.disconnectReplicas()
+}
+
+// disconnectReplicas drops all the replicas, they have to sync again when they reconnect.
+// It must be called while holding the lock.
+func (r *Replication) disconnectReplicas() {
+	for _, replica := range r.Replicas {
+		r.dropReplica(replica)
+	}
+}
+
+// setLinkState records a transition of the link with the master.
+func (r *Replication) setLinkState(state MasterLinkState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	conn, err := connectToMaster(s.config.Replication.MasterHost, s.config.Replication.MasterPort)
+	if state == MasterLinkStateConnected {
+		r.linkUp = true
+	} else if r.linkState == MasterLinkStateConnected || r.linkDownSince.IsZero() {
+		r.linkDownSince = time.Now()
+	}
+
+	// Each step of the handshake and sync counts as hearing from the master for the timeout.
+	if state != MasterLinkStateConnecting {
+		r.linkLastIO = time.Now()
+	}
+
+	r.linkState = state
+}
+
+// resetBackoff reports whether the link with the master was up since the last call,
+// in which case the reconnection backoff should start over.
+func (r *Replication) resetBackoff() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wasUp := r.linkUp
+	r.linkUp = false
+
+	return wasUp
+}
+
+// touchLink records that data was received from the master.
+func (r *Replication) touchLink() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.linkLastIO = time.Now()
+}
+
+// setMasterConn records the connection to the master so it can be closed if the master times out,
+// a nil conn means the replica isn't connected.
+func (r *Replication) setMasterConn(conn Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.masterConn = conn
+	r.linkLastIO = time.Now()
+}
+
+// handshake is what a connection that's about to become a replica announced with REPLCONF.
+type handshake struct {
+	listeningPort int
+	capaEOF       bool
+}
+
+// handshake returns the handshake of the connection at addr, creating it if needed.
+// It must be called while holding the lock.
+func (r *Replication) handshake(addr string) *handshake {
+	h, ok := r.handshakes[addr]
+
+	if !ok {
+		h = &handshake{}
+		r.handshakes[addr] = h
+	}
+
+	return h
+}
+
+// SetListeningPort records the port announced by a connection that's about to become a replica.
+func (r *Replication) SetListeningPort(addr string, port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handshake(addr).listeningPort = port
+}
+
+// SetCapa records a capability announced by a connection that's about to become a replica.
+// Unknown capabilities are ignored.
+func (r *Replication) SetCapa(addr string, capa string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if strings.EqualFold(capa, "eof") {
+		r.handshake(addr).capaEOF = true
+	}
+}
+
+// Ack records the replication offset acknowledged by the replica at addr.
+func (r *Replication) Ack(addr string, offset int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replica, ok := r.Replicas[addr]
+
+	if !ok {
+		return
+	}
+
+	replica.SetOffset(offset)
+
+	close(r.acked)
+	r.acked = make(chan struct{})
+}
+
+// Wait blocks until at least numReplicas replicas acknowledged the current replication offset
+// or the timeout expires, a zero timeout blocks forever. It returns the number of replicas that acknowledged it.
+func (r *Replication) Wait(numReplicas int, timeout time.Duration) int {
+	r.config.Mu.RLock()
+	offset := r.config.Replication.MasterReplOffset
+	r.config.Mu.RUnlock()
+
+	acked, ch := r.countAcked(offset)
+
+	if acked >= numReplicas {
+		return acked
+	}
+
+	r.requestAck()
+
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	for acked < numReplicas {
+		select {
+		case <-ch:
+			acked, ch = r.countAcked(offset)
+		case <-expired:
+			return acked
+		}
+	}
+
+	return acked
+}
+
+// countAcked returns the number of online replicas that acknowledged the given offset,
+// along with a channel that's closed on the next acknowledgement.
+func (r *Replication) countAcked(offset int) (int, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+
+	for _, replica := range r.Replicas {
+		replica.mu.Lock()
+
+		if replica.state == ReplicaStateOnline && replica.Offset >= offset {
+			count++
+		}
+
+		replica.mu.Unlock()
+	}
+
+	return count, r.acked
+}
+
+// requestAck asks all the replicas to acknowledge their replication offset.
+func (r *Replication) requestAck() {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.feed(createCommand("REPLCONF", "GETACK", "*").Encode())
+}
+
+// disconnected forgets about a connection that was closed, removing it from the replicas if it was one.
+func (r *Replication) disconnected(conn Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.handshakes, conn.Addr())
+
+	if replica, ok := r.Replicas[conn.Addr()]; ok && replica.Conn == conn {
+		r.dropReplica(replica)
+	}
+}
+
+// newReplica creates a replica for a connection that sent PSYNC, waiting for its initial data.
+// It must be called while holding the lock.
+func (r *Replication) newReplica(conn Conn) *Replica {
+	replica := &Replica{
+		Conn:    conn,
+		state:   ReplicaStateWaitBgsave,
+		lastAck: time.Now(),
+	}
+
+	replica.outputReady = sync.NewCond(&replica.mu)
+
+	if h, ok := r.handshakes[conn.Addr()]; ok {
+		replica.ListeningPort = h.listeningPort
+		replica.capaEOF = h.capaEOF
+	}
+
+	delete(r.handshakes, conn.Addr())
+
+	return replica
+}
+
+// Sync synchronizes a replica that sent PSYNC with the given replication ID and offset,
+// which is the offset of the first byte it's missing. Only the missing bytes are sent if they are still
+// in the backlog, otherwise a full resynchronization is done.
+func (r *Replication) Sync(conn Conn, replId string, offset int) error {
+	// A replica can only serve its own replicas the data and stream of its master once it's synced with it.
+	if !r.canServeSync() {
+		return conn.Reply(resp.NewSimpleError("NOMASTERLINK Can't SYNC while not connected with my master"))
+	}
+
+	ok, err := r.partialResync(conn, replId, offset)
+
+	if ok || err != nil {
+		return err
+	}
+
+	return r.FullResync(conn)
+}
+
+// canServeSync reports whether the server is a master or a replica connected to its master.
+func (r *Replication) canServeSync() bool {
+	r.config.Mu.RLock()
+	isSlave := r.config.Replication.Role == config.RoleModeSlave
+	r.config.Mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !isSlave || r.linkState == MasterLinkStateConnected
+}
+
+// partialResync sends the replica the part of the replication stream it's missing from the backlog.
+// It returns false if that's not possible and a full resynchronization is needed.
+func (r *Replication) partialResync(conn Conn, replId string, offset int) (bool, error) {
+	r.writeMu.Lock()
+
+	r.config.Mu.RLock()
+	masterReplId := r.config.Replication.MasterReplID
+	// The secondary ID is the ID of the previous master, valid up to the offset where we got promoted.
+	isPrevious := replId == r.config.Replication.MasterReplID2 && offset <= r.config.Replication.SecondReplOffset
+	r.config.Mu.RUnlock()
+
+	if replId != masterReplId && !isPrevious {
+		r.writeMu.Unlock()
+		return false, nil
+	}
+
+	r.mu.Lock()
+
+	var replica *Replica
+	missing, ok := r.backlog.From(offset)
+
+	if ok {
+		replica = r.newReplica(conn)
+		r.addReplica(replica)
+	}
+
+	r.mu.Unlock()
+
+	r.writeMu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	err := conn.Reply(resp.NewSimpleString("CONTINUE " + masterReplId))
+
+	if err == nil {
+		err = conn.Reply(rawMessage(missing))
+	}
 
 	if err != nil {
-		fmt.Println("Failed to connect to master", err)
+		r.removeReplica(replica)
+		return true, err
+	}
+
+	replica.setOnline(r.writeFailed)
+
+	fmt.Printf("Partial resynchronization with replica %v succeeded, sent %v bytes\n", conn.Addr(), len(missing))
+
+	return true, nil
+}
+
+// FullResync sends a snapshot of the database to a new replica, followed by the commands written since the snapshot.
+func (r *Replication) FullResync(conn Conn) error {
+	// Hold the write lock so no write command is applied or propagated
+	// between taking the snapshot, reading the offset, and registering the replica.
+	r.writeMu.Lock()
+
+	r.config.Mu.RLock()
+	replId := r.config.Replication.MasterReplID
+	replOffset := r.config.Replication.MasterReplOffset
+	diskless := r.config.Replication.ReplDisklessSync
+	dir := r.config.Persistence.Dir
+	r.config.Mu.RUnlock()
+
+	snapshot := r.db.Clone()
+
+	r.mu.Lock()
+	replica := r.newReplica(conn)
+	r.addReplica(replica)
+	r.mu.Unlock()
+
+	r.writeMu.Unlock()
+
+	err := conn.Reply(resp.NewSimpleString(fmt.Sprintf("FULLRESYNC %v %v", replId, replOffset)))
+
+	if err != nil {
+		r.removeReplica(replica)
+		return err
+	}
+
+	// Replicas that can't find the end of the RDB without knowing its length beforehand are sent one saved to disk.
+	if diskless && replica.capaEOF {
+		err = sendRDBDiskless(conn, snapshot)
+	} else {
+		err = sendRDBFromDisk(conn, snapshot, dir)
+	}
+
+	if err != nil {
+		r.removeReplica(replica)
+		return err
+	}
+
+	replica.setOnline(r.writeFailed)
+
+	fmt.Println("Synchronization with replica", conn.Addr(), "succeeded")
+
+	return nil
+}
+
+// sendRDBDiskless streams an RDB of the snapshot straight to the replica as it's encoded.
+// As its length isn't known in advance, it's sent as '$EOF:<mark>\r\n<content><mark>' with a random 40 bytes mark.
+func sendRDBDiskless(conn Conn, snapshot *storage.Database) error {
+	mark := config.RandomID(rdbEOFMarkLength)
+
+	w := bufio.NewWriter(connWriter{conn})
+
+	w.WriteString("$EOF:" + mark + "\r\n")
+
+	if err := rdb.NewEncoder(w).Encode(snapshot); err != nil {
+		return err
+	}
+
+	w.WriteString(mark)
+
+	return w.Flush()
+}
+
+// sendRDBFromDisk saves an RDB of the snapshot to a temporary file in dir, then sends it to the replica
+// as a bulk string without the trailing CRLF.
+func sendRDBFromDisk(conn Conn, snapshot *storage.Database, dir string) error {
+	f, err := os.CreateTemp(dir, "temp-repl-*.rdb")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	if err := rdb.NewEncoder(w).Encode(snapshot); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := conn.Reply(rawMessage(fmt.Sprintf("$%d\r\n", size))); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(connWriter{conn}, f)
+
+	return err
+}
+
+// connWriter writes raw bytes to a connection.
+type connWriter struct {
+	conn Conn
+}
+
+func (w connWriter) Write(p []byte) (int, error) {
+	if err := w.conn.Reply(rawMessage(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// addReplica registers a replica that's syncing with the master.
+// It must be called while holding the lock.
+func (r *Replication) addReplica(replica *Replica) {
+	r.Replicas[replica.Addr()] = replica
+	r.updateConnectedSlaves()
+}
+
+// removeReplica disconnects a replica and stops sending it the replication stream.
+func (r *Replication) removeReplica(replica *Replica) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropReplica(replica)
+}
+
+// dropReplica is like removeReplica but it must be called while holding the lock.
+func (r *Replication) dropReplica(replica *Replica) {
+	if r.Replicas[replica.Addr()] != replica {
+		return
+	}
+
+	delete(r.Replicas, replica.Addr())
+	replica.close()
+
+	r.updateConnectedSlaves()
+}
+
+// writeFailed disconnects a replica whose connection failed while writing the replication stream to it.
+func (r *Replication) writeFailed(replica *Replica, err error) {
+	fmt.Println("Failed to send replication stream to replica", replica.Addr(), err)
+	r.removeReplica(replica)
+}
+
+// updateConnectedSlaves reflects the number of replicas in the config.
+// It must be called while holding the lock, which is always taken before the config lock.
+func (r *Replication) updateConnectedSlaves() {
+	r.config.Mu.Lock()
+	r.config.Replication.ConnectedSlaves = uint(len(r.Replicas))
+	r.config.Mu.Unlock()
+}
+
+// feed appends an encoded write command to the replication stream and sends it to all the replicas.
+// It must be called while holding the write lock so commands are sent in the order they are applied.
+func (r *Replication) feed(msg string) {
+	r.config.Mu.Lock()
+	r.config.Replication.MasterReplOffset += len(msg)
+	limit := r.config.Replication.ReplicaOutputBufferLimit
+	r.config.Mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.backlog.Write([]byte(msg))
+
+	for _, replica := range r.Replicas {
+		if err := replica.send(msg, limit); err != nil {
+			fmt.Println("Disconnecting replica", replica.Addr(), "for overcoming the output buffer limit")
+			r.dropReplica(replica)
+		}
+	}
+}
+
+// cron pings the replicas and drops the ones that timed out when the server is a master,
+// or drops the link with the master if it timed out when the server is a replica.
+func (r *Replication) cron() {
+	r.config.Mu.RLock()
+	role := r.config.Replication.Role
+	pingPeriod := time.Duration(r.config.Replication.ReplPingReplicaPeriod) * time.Second
+	timeout := time.Duration(r.config.Replication.ReplTimeout) * time.Second
+	r.config.Mu.RUnlock()
+
+	r.checkReplicasTimeout(timeout)
+
+	// Replicas pass the pings of their master on to their own replicas.
+	if role == config.RoleModeSlave {
+		r.checkMasterTimeout(timeout)
+		return
+	}
+
+	r.mu.Lock()
+	shouldPing := len(r.Replicas) > 0 && time.Since(r.lastPing) >= pingPeriod
+	r.mu.Unlock()
+
+	if shouldPing {
+		r.pingReplicas()
+	}
+}
+
+// pingReplicas sends a PING through the replication stream, so the replicas know the master is alive
+// even when there are no writes.
+func (r *Replication) pingReplicas() {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.feed(createCommand("PING").Encode())
+
+	r.mu.Lock()
+	r.lastPing = time.Now()
+	r.mu.Unlock()
+}
+
+// checkReplicasTimeout drops the online replicas that didn't acknowledge their offset within the timeout.
+// Replicas still waiting for the snapshot don't send acknowledgements yet, so they're not checked.
+func (r *Replication) checkReplicasTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, replica := range r.Replicas {
+		replica.mu.Lock()
+		timedOut := replica.state == ReplicaStateOnline && time.Since(replica.lastAck) > timeout
+		replica.mu.Unlock()
+
+		if timedOut {
+			fmt.Println("Disconnecting timed out replica", replica.Addr())
+			r.dropReplica(replica)
+		}
+	}
+}
+
+// checkMasterTimeout closes the connection with the master if nothing was received from it within the timeout,
+// so the replica reconnects.
+func (r *Replication) checkMasterTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.masterConn == nil || time.Since(r.linkLastIO) <= timeout {
 		return
 	}
 
+	fmt.Println("Timeout connecting to master, dropping the link")
+
+	r.masterConn.Close()
+	r.masterConn = nil
+}
+
+func (r *Replication) String() string {
+	r.config.Mu.RLock()
+	c := r.config.Replication
+	r.config.Mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := strings.Builder{}
+
+	b.WriteString("# Replication\n")
+
+	b.WriteString(config.Entry("role", c.Role))
+
+	if c.Role == config.RoleModeSlave {
+		linkUp := r.linkState == MasterLinkStateConnected
+		linkStatus := "down"
+		lastIO := -1
+
+		if linkUp {
+			linkStatus = "up"
+			lastIO = int(time.Since(r.linkLastIO).Seconds())
+		}
+
+		b.WriteString(config.Entry("master_host", c.MasterHost))
+		b.WriteString(config.Entry("master_port", c.MasterPort))
+		b.WriteString(config.Entry("master_link_status", linkStatus))
+		b.WriteString(config.Entry("master_last_io_seconds_ago", lastIO))
+		b.WriteString(config.Entry("master_sync_in_progress", boolToInt(r.linkState == MasterLinkStateSync)))
+		b.WriteString(config.Entry("slave_read_only", boolToInt(c.ReplicaReadOnly)))
+
+		if !linkUp {
+			b.WriteString(config.Entry("master_link_down_since_seconds", int(time.Since(r.linkDownSince).Seconds())))
+		}
+	}
+
+	b.WriteString(config.Entry("connected_slaves", c.ConnectedSlaves))
+
+	i := 0
+
+	for _, replica := range r.Replicas {
+		replica.mu.Lock()
+
+		ip, _, _ := net.SplitHostPort(replica.Addr())
+		lag := int(time.Since(replica.lastAck).Seconds())
+		value := fmt.Sprintf("ip=%v,port=%v,state=%v,offset=%v,lag=%v", ip, replica.ListeningPort, replica.state, replica.Offset, lag)
+
+		replica.mu.Unlock()
+
+		b.WriteString(config.Entry(fmt.Sprintf("slave%d", i), value))
+		i++
+	}
+
+	b.WriteString(config.Entry("master_replid", c.MasterReplID))
+	b.WriteString(config.Entry("master_replid2", c.MasterReplID2))
+	b.WriteString(config.Entry("master_repl_offset", c.MasterReplOffset))
+	b.WriteString(config.Entry("second_repl_offset", c.SecondReplOffset))
+	b.WriteString(config.Entry("repl_backlog_active", 1))
+	b.WriteString(config.Entry("repl_backlog_size", r.backlog.Size()))
+	b.WriteString(config.Entry("repl_backlog_first_byte_offset", r.backlog.FirstByteOffset()))
+	b.WriteString(config.Entry("repl_backlog_histlen", r.backlog.HistLen()))
+
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// startReplication keeps the replica connected to its master, reconnecting with exponential backoff
+// whenever connecting, the handshake, the sync, or the command stream fails, until ctx is cancelled.
+func (s *Server) startReplication(ctx context.Context) {
+	backoff := minReconnectBackoff
+
+	for {
+		err := s.connectAndSync(ctx)
+
+		s.replication.setLinkState(MasterLinkStateConnecting)
+
+		if ctx.Err() != nil {
+			fmt.Println("Stopped replicating from master")
+			return
+		}
+
+		if err != nil {
+			fmt.Println("Replication with master failed:", err)
+		}
+
+		// Start over with a short backoff once a link was successfully established.
+		if s.replication.resetBackoff() {
+			backoff = minReconnectBackoff
+		}
+
+		fmt.Printf("Reconnecting to master in %v\n", backoff)
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopped replicating from master")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// connectAndSync connects to the master, performs the handshake and sync,
+// then applies the command stream until the connection fails or ctx is cancelled.
+func (s *Server) connectAndSync(ctx context.Context) error {
+	s.config.Mu.RLock()
+	host := s.config.Replication.MasterHost
+	port := s.config.Replication.MasterPort
+	listeningPort := int(s.config.Server.Port)
+	s.config.Mu.RUnlock()
+
+	s.replication.setLinkState(MasterLinkStateConnecting)
+
+	conn, err := connectToMaster(host, port)
+
+	if err != nil {
+		return fmt.Errorf("failed to connect to master: %w", err)
+	}
+
 	defer conn.Close()
 
+	s.replication.setMasterConn(conn)
+	defer s.replication.setMasterConn(nil)
+
+	// Closing the connection interrupts whatever step is in progress.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
 	fmt.Println("Connected to master")
 
+	s.replication.setLinkState(MasterLinkStateHandshake)
+
 	buf := conn.Reader()
 	parser := resp.NewParser(buf)
 
@@ -53,34 +1057,43 @@ func (s *Server) startReplication() {
 	err = pingMaster(conn, parser)
 
 	if err != nil {
-		fmt.Println("Failed to ping master", err)
-		return
+		return fmt.Errorf("failed to ping master: %w", err)
 	}
 
 	fmt.Println("Master replied with PONG, starting replication")
 
-	err = sendReplConf(conn, parser, int(s.config.Server.Port))
+	err = sendReplConf(conn, parser, listeningPort)
 
 	if err != nil {
-		fmt.Println("Failed to send REPLCONF to master", err)
-		return
+		return fmt.Errorf("failed to send REPLCONF to master: %w", err)
 	}
 
 	fmt.Println("Sent REPLCONF to master")
 
-	s.syncWithMaster(conn, parser)
+	s.replication.setLinkState(MasterLinkStateSync)
 
-	s.config.Mu.Unlock()
+	err = s.syncWithMaster(conn, parser)
+
+	if err != nil {
+		return fmt.Errorf("failed to sync with master: %w", err)
+	}
 
 	fmt.Println("Finished syncing with master")
 
-	s.handleMasterCommands(conn)
+	s.replication.setLinkState(MasterLinkStateConnected)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go s.sendAcks(conn, done)
+
+	return s.handleMasterCommands(conn)
 }
 
 func connectToMaster(host string, port uint64) (Conn, error) {
 	addr := fmt.Sprintf("%v:%v", host, port)
 
-	c, err := net.Dial("tcp", addr)
+	c, err := net.DialTimeout("tcp", addr, dialTimeout)
 
 	if err != nil {
 		return nil, err
@@ -152,8 +1165,16 @@ func sendReplConf(conn Conn, parser *resp.Parser, port int) error {
 }
 
 func (s *Server) syncWithMaster(conn Conn, parser *resp.Parser) error {
+	s.config.Mu.RLock()
 	replId := s.config.Replication.MasterReplID
-	offset := strconv.Itoa(s.config.Replication.MasterReplOffset)
+	// Ask for the stream starting at the first byte we haven't processed yet.
+	offset := strconv.Itoa(s.config.Replication.MasterReplOffset + 1)
+	s.config.Mu.RUnlock()
+
+	if replId == "?" {
+		offset = "-1"
+	}
+
 	cmd := createCommand("PSYNC", replId, offset)
 	err := conn.Reply(cmd)
 
@@ -161,9 +1182,6 @@ func (s *Server) syncWithMaster(conn Conn, parser *resp.Parser) error {
 		return err
 	}
 
-	// TODO: Read RDB from master and load it into the database
-	// Should do so after implementing RDB encoding/decoding.
-
 	result, err := parser.NextSimpleString()
 
 	if err != nil {
@@ -177,9 +1195,20 @@ func (s *Server) syncWithMaster(conn Conn, parser *resp.Parser) error {
 	switch syncArgs[0] {
 	case "CONTINUE":
 		fmt.Println("Master replied with CONTINUE, partial sync will follow")
+
+		// The master may have a new replication ID if it was promoted from a replica.
+		if len(syncArgs) > 1 {
+			s.writeMu.Lock()
+			s.replication.shiftReplID(syncArgs[1])
+			s.writeMu.Unlock()
+		}
 	case "FULLRESYNC":
 		fmt.Println("Master requested a full sync")
 
+		if len(syncArgs) != 3 {
+			return fmt.Errorf("invalid PSYNC result '%v' from master", result)
+		}
+
 		replId := syncArgs[1]
 		offset, err := strconv.Atoi(syncArgs[2])
 
@@ -187,15 +1216,20 @@ func (s *Server) syncWithMaster(conn Conn, parser *resp.Parser) error {
 			return err
 		}
 
-		s.config.Replication.MasterReplID = replId
-		s.config.Replication.MasterReplOffset = offset
+		content, err := s.replication.readRDB(conn)
 
-		// Read RDB file from server
-		readRDB(conn)
+		if err != nil {
+			return err
+		}
 
-		// TODO: update the server configuration with the RDB file.
 		fmt.Println("Received RDB from master")
 
+		if err := s.loadMasterRDB(content, replId, offset); err != nil {
+			return err
+		}
+
+		fmt.Println("Loaded RDB from master")
+
 	default:
 		return fmt.Errorf("invalid PSYNC result '%v' from master", result)
 	}
@@ -203,7 +1237,25 @@ func (s *Server) syncWithMaster(conn Conn, parser *resp.Parser) error {
 	return nil
 }
 
-func readRDB(conn Conn) ([]byte, error) {
+// linkReader reads from the master, touching the link on every read so that receiving and loading
+// a large RDB isn't mistaken for a master that timed out.
+type linkReader struct {
+	r           io.Reader
+	replication *Replication
+}
+
+func (l *linkReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+
+	if n > 0 {
+		l.replication.touchLink()
+	}
+
+	return n, err
+}
+
+// readRDB reads the RDB the master sends for a full resynchronization.
+func (r *Replication) readRDB(conn Conn) ([]byte, error) {
 	buf := conn.Reader()
 
 	t, err := buf.ReadByte()
@@ -222,80 +1274,165 @@ func readRDB(conn Conn) ([]byte, error) {
 		return nil, err
 	}
 
-	length, err := strconv.Atoi(s[:len(s)-1])
+	// Discard \n
+	if _, err := buf.Discard(1); err != nil {
+		return nil, err
+	}
+
+	s = s[:len(s)-1]
+
+	if mark, ok := strings.CutPrefix(s, "EOF:"); ok {
+		return r.readRDBUntilMark(buf, mark)
+	}
+
+	length, err := strconv.Atoi(s)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Discard \n
-	buf.Discard(1)
-
-	rdb := make([]byte, length)
-	n, err := buf.Read(rdb)
+	content := make([]byte, length)
+	_, err = io.ReadFull(&linkReader{r: buf, replication: r}, content)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if n != length {
-		return nil, fmt.Errorf("invalid RDB file format")
+	return content, nil
+}
+
+// readRDBUntilMark reads an RDB sent without its length in advance, which ends with the given mark.
+func (r *Replication) readRDBUntilMark(buf *bufio.Reader, mark string) ([]byte, error) {
+	if len(mark) != rdbEOFMarkLength {
+		return nil, fmt.Errorf("invalid RDB EOF mark %q", mark)
+	}
+
+	var content []byte
+
+	for {
+		// Reading up to the last byte of the mark at a time never consumes the commands that follow the RDB.
+		chunk, err := buf.ReadSlice(mark[len(mark)-1])
+		content = append(content, chunk...)
+		r.touchLink()
+
+		if err == nil && bytes.HasSuffix(content, []byte(mark)) {
+			return content[:len(content)-len(mark)], nil
+		}
+
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// loadMasterRDB replaces the contents of the database with the RDB received from the master,
+// taken at the given replication ID and offset.
+// Expired keys are loaded as well, as the master is responsible for deleting them.
+func (s *Server) loadMasterRDB(content []byte, replId string, offset int) error {
+	loaded := storage.NewDatabase()
+
+	if err := rdb.NewDecoder(&linkReader{r: bytes.NewReader(content), replication: s.replication}).Decode(loaded); err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+
+	// Waiting for the write lock may take a while too.
+	s.replication.touchLink()
+	s.db.Replace(loaded)
+
+	// Only take the new replication ID and offset once the data is loaded,
+	// otherwise a failed sync could be continued partially on reconnection.
+	// The history of any previous ID doesn't match the new data, so sub-replicas can't continue from it.
+	s.config.Mu.Lock()
+	s.config.Replication.MasterReplID = replId
+	s.config.Replication.MasterReplOffset = offset
+	s.config.Replication.ClearReplID2()
+	s.config.Mu.Unlock()
+
+	s.replication.resetBacklog(offset)
+
+	s.writeMu.Unlock()
+
+	// The append-only file no longer matches the database, so rebuild it from the new contents.
+	if s.persistence.aof != nil {
+		if err := s.persistence.BgRewriteAOF(); err != nil {
+			fmt.Println("Failed to rewrite the append only file after syncing with master:", err)
+		}
 	}
 
-	return rdb, nil
+	return nil
 }
 
-func (s *Server) handleMasterCommands(conn Conn) {
+// handleMasterCommands applies the command stream from the master until the connection fails.
+func (s *Server) handleMasterCommands(conn Conn) error {
 	fmt.Println("Listening for commands from master", conn.Addr())
 
 	buf := conn.Reader()
 
 	for {
-		cmd, args, err := parseCommand(buf)
+		cmd, args, raw, err := parseCommand(buf)
 
 		if err == io.EOF {
 			fmt.Println("Master closed connection", conn.Addr())
-			return
+			return err
 		}
 
-		ctx := s.newContext(conn, cmd, args, true)
-
 		if err != nil {
-			fmt.Println("Failed to parse master command", err)
-			return
+			return fmt.Errorf("failed to parse master command: %w", err)
 		}
 
-		s.config.Mu.Lock()
-		// TODO: refactor inefficient re-encoding on the command to get the length.
-		s.config.Replication.MasterReplOffset += len(createCommand(cmd, args...).Encode())
-		s.config.Mu.Unlock()
+		s.replication.touchLink()
+
+		ctx := s.newContext(conn, cmd, args, true)
+		ctx.raw = raw
 
 		handler, ok := s.commands[cmd]
 
-		if ok {
-			handler.Handler(ctx)
-		} else {
+		if !ok {
 			fmt.Printf("ERR unknown command '%v'\n", cmd)
 		}
+
+		s.applyMasterCommand(ctx, handler, raw)
 	}
 }
 
-func (s *Server) forwardToReplicas(cmd string, args ...string) {
-	// TODO: Refactor to buffer commands and use ACKs to ensure all replicas received the command.
-	// TODO: The client has already encoded the command while sending it to our server, so no need to
-	// decode it and then encode it once again as we are doing here, we should refactor the parser to return
-	// the original message sent by the client for logic like forwarding to be more efficient.
-	msg := createCommand(cmd, args...)
+// applyMasterCommand runs a command from the master, then passes it on to the sub-replicas exactly as it was received.
+// The write lock is held throughout, so sub-replicas syncing meanwhile get a snapshot that matches the offset.
+func (s *Server) applyMasterCommand(ctx *Context, handler *Command, raw []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 
-	s.config.Mu.Lock()
-	s.config.Replication.MasterReplOffset += len(msg.Encode())
-	s.config.Mu.Unlock()
+	if handler != nil {
+		handler.Handler(ctx)
 
-	for _, replica := range s.replication.Replicas {
-		// NOTE: calling Reply with an Encodable each time is probably inefficient as it will encode the message each time.
-		// as the message doesn't change.
+		if handler.IsWrite {
+			s.persistence.feedAOF(ctx.propagation())
+		}
+	}
+
+	// The offset is updated after running the command, so REPLCONF GETACK reports the offset before itself.
+	s.replication.feed(string(raw))
+}
+
+// sendAcks periodically acknowledges the processed replication offset to the master until done is closed.
+func (s *Server) sendAcks(conn Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
 
-		// Refactor to have ReplyString for example along with the default Reply which takes in an Encodable.
-		replica.Reply(msg)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.config.Mu.RLock()
+			offset := s.config.Replication.MasterReplOffset
+			s.config.Mu.RUnlock()
+
+			if err := conn.Reply(createCommand("REPLCONF", "ACK", strconv.Itoa(offset))); err != nil {
+				fmt.Println("Failed to send ACK to master", err)
+				return
+			}
+		}
 	}
 }
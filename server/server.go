@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/a7medev/goredis/config"
 	"github.com/a7medev/goredis/resp"
@@ -18,25 +19,58 @@ const BufferSize = 4096
 type Context struct {
 	Conn
 
-	Config     *config.Config
-	DB         *storage.Database
-	Replcation Replication
+	Config      *config.Config
+	DB          *storage.Database
+	Replcation  *Replication
+	Persistence *Persistence
 
 	Command string
 	Args    []string
 
 	FromMaster bool
+
+	// raw is the command exactly as it was received, if known.
+	raw []byte
+	// propagated is the command written to the AOF and sent to replicas instead of the original one, if set.
+	propagated []string
+}
+
+// Propagate replaces the command written to the AOF and sent to replicas, which is useful for commands
+// that would have a different effect when replayed later or elsewhere, like a relative expiry.
+func (c *Context) Propagate(cmd string, args ...string) {
+	c.propagated = append([]string{cmd}, args...)
+}
+
+// propagation returns the encoded command to be written to the AOF and sent to replicas.
+// The command is sent as it was received unless it was replaced with Propagate.
+func (c *Context) propagation() string {
+	if c.propagated != nil {
+		return createCommand(c.propagated[0], c.propagated[1:]...).Encode()
+	}
+
+	if c.raw != nil {
+		return string(c.raw)
+	}
+
+	return createCommand(c.Command, c.Args...).Encode()
 }
 
 func (s *Server) newContext(conn Conn, command string, args []string, fromMaster bool) *Context {
+	db := s.db
+
+	if fromMaster {
+		db = s.db.FromMaster()
+	}
+
 	return &Context{
-		Conn:       conn,
-		Config:     s.config,
-		DB:         s.db,
-		Replcation: s.replication,
-		Command:    command,
-		Args:       args,
-		FromMaster: fromMaster,
+		Conn:        conn,
+		Config:      s.config,
+		DB:          db,
+		Replcation:  s.replication,
+		Persistence: s.persistence,
+		Command:     command,
+		Args:        args,
+		FromMaster:  fromMaster,
 	}
 }
 
@@ -46,6 +80,8 @@ type Command struct {
 	Name    string
 	Handler CommandHandler
 	IsWrite bool
+	// DenyOOM is set for commands that may use more memory, which are rejected when over the memory limit.
+	DenyOOM bool
 }
 
 func (c *Command) WithIsWrite(isWrite bool) *Command {
@@ -53,25 +89,48 @@ func (c *Command) WithIsWrite(isWrite bool) *Command {
 	return c
 }
 
+func (c *Command) WithDenyOOM(denyOOM bool) *Command {
+	c.DenyOOM = denyOOM
+	return c
+}
+
 type Server struct {
 	listener net.Listener
 	config   *config.Config
 	db       *storage.Database
 	commands map[string]*Command
 
-	replication Replication
+	replication *Replication
+	persistence *Persistence
+
+	// writeMu serializes write commands so they are applied and propagated in the same order.
+	// As a result writes never run in parallel, the sharded database only lets reads run alongside them.
+	writeMu sync.Mutex
 }
 
 func NewServer(cfg *config.Config) *Server {
 	return &Server{
-		config:      cfg,
-		commands:    make(map[string]*Command),
-		replication: Replication{Replicas: make(map[string]*Replica)},
+		config:   cfg,
+		commands: make(map[string]*Command),
 	}
 }
 
 // TODO: make the server exit gracefully.
 func (s *Server) Start() {
+	s.db = storage.NewDatabase()
+
+	s.config.Mu.RLock()
+	s.db.SetReplicaMode(s.config.Replication.Role == config.RoleModeSlave)
+	s.config.Mu.RUnlock()
+	s.persistence = NewPersistence(s.config, s.db, &s.writeMu)
+	s.replication = NewReplication(s.config, s.db, &s.writeMu, s.startReplication)
+
+	if err := s.persistence.load(s.replay); err != nil {
+		log.Fatalln("Failed to load data from disk:", err)
+	}
+
+	go s.cron()
+
 	s.config.Mu.RLock()
 
 	addr := fmt.Sprintf(":%v", s.config.Server.Port)
@@ -85,10 +144,8 @@ func (s *Server) Start() {
 
 	s.listener = ln
 
-	s.db = storage.NewDatabase()
-
 	if s.config.Replication.Role == config.RoleModeSlave {
-		go s.startReplication()
+		s.replication.startReplica()
 	}
 
 	s.config.Mu.RUnlock()
@@ -114,20 +171,21 @@ func (s *Server) AddCommand(name string, handler CommandHandler) *Command {
 }
 
 // parseCommand parses the recieved Redis command from the client.
-// It reads the command, arguments, and the error if any.
-func parseCommand(buf *bufio.Reader) (string, []string, error) {
+// It reads the command, arguments, the exact bytes the command was sent as, and the error if any.
+func parseCommand(buf *bufio.Reader) (string, []string, []byte, error) {
 	p := resp.NewParser(buf)
+	p.StartRecording()
 
 	cmdLen, err := p.NextArrayLength()
 
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	cmd, err := p.NextBulkString()
 
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	cmd = strings.ToUpper(cmd)
@@ -139,13 +197,13 @@ func parseCommand(buf *bufio.Reader) (string, []string, error) {
 		arg, err := p.NextBulkString()
 
 		if err != nil {
-			return "", nil, err
+			return "", nil, nil, err
 		}
 
 		args[i] = arg
 	}
 
-	return cmd, args, nil
+	return cmd, args, p.Recorded(), nil
 }
 
 func createCommand(cmd string, args ...string) *resp.Array {
@@ -158,15 +216,102 @@ func createCommand(cmd string, args ...string) *resp.Array {
 	return arr
 }
 
+// execute runs the command handler, write commands are then written to the AOF and sent to replicas
+// before their reply is sent.
+func (s *Server) execute(ctx *Context, cmd *Command) {
+	if !cmd.IsWrite {
+		cmd.Handler(ctx)
+
+		// Reading expired keys deletes them, which has to be propagated like a write.
+		if s.db.HasExpired() {
+			s.writeMu.Lock()
+			s.propagateExpired()
+			s.writeMu.Unlock()
+		}
+
+		return
+	}
+
+	// The reply is held back until the command is written to the AOF, and sent once the write lock is released.
+	conn := &bufferedConn{Conn: ctx.Conn}
+	ctx.Conn = conn
+	defer conn.flush()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.persistence.aofWriteErr(); err != nil {
+		ctx.Reply(resp.NewSimpleError("MISCONF Errors writing to the AOF file: " + err.Error()))
+		return
+	}
+
+	if !s.freeMemoryIfNeeded() && cmd.DenyOOM {
+		ctx.Reply(resp.NewSimpleError("OOM command not allowed when used memory > 'maxmemory'."))
+		return
+	}
+
+	dirty := s.db.Dirty()
+
+	cmd.Handler(ctx)
+
+	// Keys that expired while running the command are deleted before it's applied elsewhere.
+	s.propagateExpired()
+
+	// Commands that didn't change anything, like SET NX on an existing key, aren't propagated.
+	if s.db.Dirty() == dirty {
+		return
+	}
+
+	propagated := ctx.propagation()
+
+	s.propagate(propagated)
+}
+
+// propagateExpired propagates a DEL for each key that expired since the last call,
+// so replicas and the AOF delete them at the same point of the stream.
+// It must be called while holding the write lock.
+func (s *Server) propagateExpired() {
+	for _, key := range s.db.DrainExpired() {
+		s.propagate(createCommand("DEL", key).Encode())
+	}
+}
+
+// propagate writes an encoded command to the AOF and sends it to the replicas if the server is a master.
+// It must be called while holding the write lock.
+func (s *Server) propagate(msg string) {
+	s.persistence.feedAOF(msg)
+
+	s.config.Mu.RLock()
+	isMaster := s.config.Replication.Role == config.RoleModeMaster
+	s.config.Mu.RUnlock()
+
+	if isMaster {
+		s.replication.feed(msg)
+	}
+}
+
+// replay runs a command loaded from the AOF without replying to anyone.
+func (s *Server) replay(cmd string, args []string) {
+	handler, ok := s.commands[cmd]
+
+	if !ok {
+		fmt.Printf("Unknown command '%v' in the append-only file\n", cmd)
+		return
+	}
+
+	handler.Handler(s.newContext(discardConn{}, cmd, args, false))
+}
+
 func (s *Server) handleConn(conn Conn) {
 	defer conn.Close()
+	defer s.replication.disconnected(conn)
 
 	fmt.Println("Connection from", conn.Addr())
 
 	buf := conn.Reader()
 
 	for {
-		cmd, args, err := parseCommand(buf)
+		cmd, args, raw, err := parseCommand(buf)
 
 		if err == io.EOF {
 			fmt.Println("Client closed connection", conn.Addr())
@@ -174,6 +319,7 @@ func (s *Server) handleConn(conn Conn) {
 		}
 
 		ctx := s.newContext(conn, cmd, args, false)
+		ctx.raw = raw
 
 		if err != nil {
 			ctx.Reply(resp.NewSimpleError("ERR failed to parse command"))
@@ -182,17 +328,26 @@ func (s *Server) handleConn(conn Conn) {
 
 		handler, ok := s.commands[cmd]
 
-		if ok {
-			isMaster := s.config.Replication.Role == config.RoleModeMaster
-
-			if isMaster && handler.IsWrite {
-				go s.forwardToReplicas(cmd, args...)
-			}
-
-			handler.Handler(ctx)
-		} else {
+		if !ok {
 			msg := fmt.Sprintf("ERR unknown command '%v'", cmd)
 			ctx.Reply(resp.NewSimpleError(msg))
+			continue
 		}
+
+		if handler.IsWrite && s.isReadOnlyReplica() {
+			ctx.Reply(resp.NewSimpleError("READONLY You can't write against a read only replica."))
+			continue
+		}
+
+		s.execute(ctx, handler)
 	}
 }
+
+// isReadOnlyReplica reports whether the server is a replica that rejects writes from its clients,
+// as they would make it diverge from its master.
+func (s *Server) isReadOnlyReplica() bool {
+	s.config.Mu.RLock()
+	defer s.config.Mu.RUnlock()
+
+	return s.config.Replication.Role == config.RoleModeSlave && s.config.Replication.ReplicaReadOnly
+}
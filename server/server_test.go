@@ -0,0 +1,78 @@
+package server
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/a7medev/goredis/config"
+	"github.com/a7medev/goredis/resp"
+	"github.com/a7medev/goredis/storage"
+)
+
+// newBenchmarkServer returns a master without AOF or replicas, which runs GET and SET like the commands package does.
+func newBenchmarkServer() *Server {
+	s := NewServer(config.NewConfig(0))
+	s.db = storage.NewDatabase()
+	s.persistence = NewPersistence(s.config, s.db, &s.writeMu)
+	s.replication = NewReplication(s.config, s.db, &s.writeMu, s.startReplication)
+
+	s.AddCommand("GET", func(ctx *Context) {
+		value, _ := ctx.DB.Get(ctx.Args[0])
+		ctx.Reply(resp.NewBulkString(value))
+	})
+
+	s.AddCommand("SET", func(ctx *Context) {
+		ctx.DB.Set(ctx.Args[0], ctx.Args[1], storage.NeverExpires, storage.SetDefault, false, false)
+		ctx.Reply(resp.NewSimpleString("OK"))
+	}).WithIsWrite(true)
+
+	return s
+}
+
+// benchmarkExecute runs commands through execute from every goroutine of b.RunParallel.
+// Unlike the storage benchmarks, write commands don't scale with the cores here, since they all take the write lock
+// to be applied and propagated in the same order.
+func benchmarkExecute(b *testing.B, writeEvery int) {
+	s := newBenchmarkServer()
+	keys := make([]string, 100_000)
+
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		s.db.Set(keys[i], "value", storage.NeverExpires, storage.SetDefault, false, false)
+	}
+
+	get := s.commands["GET"]
+	set := s.commands["SET"]
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.IntN(len(keys))
+
+		for pb.Next() {
+			key := keys[i%len(keys)]
+
+			if writeEvery > 0 && i%writeEvery == 0 {
+				s.execute(s.newContext(discardConn{}, "SET", []string{key, "value"}, false), set)
+			} else {
+				s.execute(s.newContext(discardConn{}, "GET", []string{key}, false), get)
+			}
+
+			i++
+		}
+	})
+}
+
+func BenchmarkExecuteGet(b *testing.B) {
+	benchmarkExecute(b, 0)
+}
+
+func BenchmarkExecuteSet(b *testing.B) {
+	benchmarkExecute(b, 1)
+}
+
+// BenchmarkExecuteMixed runs one SET for every nine GETs.
+func BenchmarkExecuteMixed(b *testing.B) {
+	benchmarkExecute(b, 10)
+}
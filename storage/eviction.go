@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// entryOverhead is the estimated memory used by an entry besides its key and value,
+// like the map bucket, the expiry and the access information.
+const entryOverhead = 96
+
+// entrySize returns the estimated memory used by an entry.
+func entrySize(key string, entry Entry) int64 {
+	return int64(len(key) + len(entry.value) + entryOverhead)
+}
+
+// UsedMemory returns the estimated memory used by the keys in the database.
+func (db *Database) UsedMemory() int64 {
+	used := int64(0)
+
+	for _, s := range db.shards {
+		used += s.used.Load()
+	}
+
+	return used
+}
+
+const (
+	// A new key starts with lfuInitCounter so it isn't evicted right away before it gets the chance to be used.
+	lfuInitCounter = 5
+	// lfuLogFactor controls how fast the logarithmic counter grows, it reaches 255 after about a million accesses.
+	lfuLogFactor = 10
+	// The counter is decremented once every lfuDecayPeriod without accesses.
+	lfuDecayPeriod = time.Minute
+)
+
+// accessInfo is the approximated access information of a key used to pick the keys to evict.
+type accessInfo struct {
+	// lastAccess is the time of the last access in Unix milliseconds.
+	lastAccess atomic.Int64
+	// counter is a logarithmic access counter like the one Redis uses for LFU, which grows slower
+	// the larger it gets and decays over time.
+	counter atomic.Uint32
+}
+
+func newAccessInfo() *accessInfo {
+	a := &accessInfo{}
+
+	a.lastAccess.Store(time.Now().UnixMilli())
+	a.counter.Store(lfuInitCounter)
+
+	return a
+}
+
+// touch records an access to the key.
+func (a *accessInfo) touch() {
+	counter := a.decayedCounter()
+
+	if counter < math.MaxUint8 {
+		base := float64(max(int(counter)-lfuInitCounter, 0))
+
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+
+	a.counter.Store(counter)
+	a.lastAccess.Store(time.Now().UnixMilli())
+}
+
+// decayedCounter returns the access counter decremented for each decay period since the last access.
+func (a *accessInfo) decayedCounter() uint32 {
+	counter := a.counter.Load()
+	periods := uint32(a.idle() / lfuDecayPeriod)
+
+	if periods >= counter {
+		return 0
+	}
+
+	return counter - periods
+}
+
+// idle returns the time since the last access.
+func (a *accessInfo) idle() time.Duration {
+	return time.Since(time.UnixMilli(a.lastAccess.Load()))
+}
+
+// EvictBy is how the key to evict is picked among the sampled keys.
+type EvictBy int
+
+const (
+	// Evict any of the sampled keys
+	EvictRandom EvictBy = iota
+	// Evict the least recently used of the sampled keys
+	EvictLRU
+	// Evict the least frequently used of the sampled keys
+	EvictLFU
+	// Evict the sampled key with the nearest expiry
+	EvictTTL
+)
+
+// Evict deletes the best key to evict according to evictBy out of a sample of random keys,
+// only sampling keys with an expiry if volatile is set. It returns false if there's no key to evict.
+func (db *Database) Evict(evictBy EvictBy, volatile bool, samples int) (string, bool) {
+	var best string
+	var bestScore float64
+	found := false
+
+	// Sample from random shards, giving up after a few rounds if they're all empty.
+	for attempt := 0; attempt < samples*4 && samples > 0; attempt++ {
+		s := db.shards[rand.IntN(shardCount)]
+
+		key, score, ok := s.sample(evictBy, volatile)
+
+		if !ok {
+			continue
+		}
+
+		if !found || score > bestScore {
+			best, bestScore, found = key, score, true
+		}
+
+		samples--
+	}
+
+	if !found {
+		return "", false
+	}
+
+	s := db.shard(best)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The key may have been deleted since it was sampled.
+	if _, ok := s.data[best]; !ok {
+		return "", false
+	}
+
+	s.remove(best)
+	db.dirty.Add(1)
+	db.evictedKeys.Add(1)
+
+	return best, true
+}
+
+// sample returns a random key of the shard with its eviction score, the higher the score the better to evict it.
+func (s *shard) sample(evictBy EvictBy, volatile bool) (string, float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var key string
+	found := false
+
+	s.randomKeys(volatile, func(k string) bool {
+		key, found = k, true
+		return false
+	})
+
+	if !found {
+		return "", 0, false
+	}
+
+	entry := s.data[key]
+
+	switch evictBy {
+	case EvictLRU:
+		return key, float64(entry.access.idle()), true
+	case EvictLFU:
+		return key, float64(math.MaxUint8 - entry.access.decayedCounter()), true
+	case EvictTTL:
+		return key, -float64(entry.expiry.Time.UnixMilli()), true
+	default:
+		return key, 0, true
+	}
+}
+
+// EvictedKeys returns the number of keys deleted to stay under the memory limit.
+func (db *Database) EvictedKeys() int64 {
+	return db.evictedKeys.Load()
+}
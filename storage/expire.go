@@ -0,0 +1,203 @@
+package storage
+
+import "time"
+
+const (
+	// activeExpireSamples is how many keys with an expiry are checked at a time by the active expiration.
+	activeExpireSamples = 20
+	// The active expiration keeps sampling a shard while more than activeExpireRepeatPercent
+	// of the sampled keys were expired, as there are likely many more.
+	activeExpireRepeatPercent = 25
+)
+
+// ActiveExpire deletes expired keys that are never read by sampling the keys with an expiry, like Redis does.
+// Each shard is sampled again and again while a large part of its sample is expired,
+// until all the shards are done or the time budget is used up, in which case the next call continues from there.
+// It returns the number of deleted keys.
+func (db *Database) ActiveExpire(budget time.Duration) int {
+	if db.replicaMode.Load() {
+		return 0
+	}
+
+	start := time.Now()
+	expired := 0
+
+	for range shardCount {
+		i := (db.expireCursor.Add(1) - 1) % shardCount
+		s := db.shards[i]
+
+		for {
+			n, sampled := db.expireSample(s)
+			expired += n
+
+			if sampled == 0 || n*100 <= sampled*activeExpireRepeatPercent || time.Since(start) > budget {
+				break
+			}
+		}
+
+		if time.Since(start) > budget {
+			break
+		}
+	}
+
+	return expired
+}
+
+// expireSample deletes the expired keys among a sample of the keys with an expiry in the shard.
+// It returns the number of deleted and sampled keys.
+func (db *Database) expireSample(s *shard) (expired, sampled int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.randomKeys(true, func(key string) bool {
+		if sampled == activeExpireSamples {
+			return false
+		}
+
+		sampled++
+
+		if s.data[key].expired() {
+			db.expire(s, key)
+			expired++
+		}
+
+		return true
+	})
+
+	return expired, sampled
+}
+
+// expire deletes a key that expired and queues it to be propagated.
+// It must be called while holding the lock of the shard.
+func (db *Database) expire(s *shard, key string) {
+	s.remove(key)
+	db.dirty.Add(1)
+	db.expiredKeys.Add(1)
+
+	db.expiredMu.Lock()
+	db.expiredQueue = append(db.expiredQueue, key)
+	db.expiredQueued.Store(int64(len(db.expiredQueue)))
+	db.expiredMu.Unlock()
+}
+
+// HasExpired reports whether there are keys that expired since the last call to DrainExpired, without locking.
+func (db *Database) HasExpired() bool {
+	return db.expiredQueued.Load() > 0
+}
+
+// DrainExpired returns the keys deleted because they expired since the last call,
+// so their deletion can be propagated to replicas and the AOF.
+func (db *Database) DrainExpired() []string {
+	if !db.HasExpired() {
+		return nil
+	}
+
+	db.expiredMu.Lock()
+	defer db.expiredMu.Unlock()
+
+	keys := db.expiredQueue
+	db.expiredQueue = nil
+	db.expiredQueued.Store(0)
+
+	return keys
+}
+
+// SetReplicaMode sets whether the database belongs to a replica. Replicas report expired keys as missing
+// but keep them until their master propagates their deletion, so they never diverge from it.
+func (db *Database) SetReplicaMode(replica bool) {
+	db.replicaMode.Store(replica)
+}
+
+// ExpiredKeys returns the number of keys deleted because they expired.
+func (db *Database) ExpiredKeys() int64 {
+	return db.expiredKeys.Load()
+}
+
+// ExpireCondition restricts when Expire changes the expiry of a key, conditions can be combined.
+type ExpireCondition int
+
+const (
+	ExpireAlways ExpireCondition = 0
+	// Only set the expiry if the key has none
+	ExpireNX ExpireCondition = 1 << iota
+	// Only set the expiry if the key already has one
+	ExpireXX
+	// Only set the expiry if it's after the current one, a key without an expiry never expires so it's never set
+	ExpireGT
+	// Only set the expiry if it's before the current one, a key without an expiry never expires so it's always set
+	ExpireLT
+)
+
+// Expire sets the expiry of an existing key if the condition holds, and reports whether it was set.
+// An expiry in the past deletes the key right away instead, which is reported as deleted, except for
+// commands from the master, whose keys are only deleted by its DEL.
+func (db *Database) Expire(key string, expiry Expiry, cond ExpireCondition) (isSet, deleted bool) {
+	s := db.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := db.lookupLocked(s, key)
+
+	if !ok {
+		return false, false
+	}
+
+	current := entry.expiry
+
+	if cond&ExpireNX != 0 && current.Expires {
+		return false, false
+	}
+
+	if cond&ExpireXX != 0 && !current.Expires {
+		return false, false
+	}
+
+	if cond&ExpireGT != 0 && (!current.Expires || !expiry.Time.After(current.Time)) {
+		return false, false
+	}
+
+	if cond&ExpireLT != 0 && current.Expires && !expiry.Time.Before(current.Time) {
+		return false, false
+	}
+
+	if !db.fromMaster && !expiry.Time.After(time.Now()) {
+		s.remove(key)
+		db.dirty.Add(1)
+
+		return true, true
+	}
+
+	entry.expiry = expiry
+	s.put(key, entry)
+	db.dirty.Add(1)
+
+	return true, false
+}
+
+// Persist removes the expiry of a key and reports whether it had one.
+func (db *Database) Persist(key string) bool {
+	s := db.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := db.lookupLocked(s, key)
+
+	if !ok || !entry.expiry.Expires {
+		return false
+	}
+
+	entry.expiry = NeverExpires
+	s.put(key, entry)
+	db.dirty.Add(1)
+
+	return true
+}
+
+// GetExpiry returns the expiry of a key, or false if the key doesn't exist.
+func (db *Database) GetExpiry(key string) (Expiry, bool) {
+	entry, ok := db.lookup(key)
+
+	return entry.expiry, ok
+}
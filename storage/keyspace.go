@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"math/rand/v2"
+
+	"github.com/a7medev/goredis/glob"
+)
+
+// Exists returns how many of the given keys exist, counting a key as many times as it's given.
+func (db *Database) Exists(keys ...string) int {
+	unlock := db.lockKeys(keys...)
+	defer unlock()
+
+	count := 0
+
+	for _, key := range keys {
+		if _, ok := db.lookupLocked(db.shard(key), key); ok {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Type returns the type of the value of a key, or "none" if it doesn't exist.
+func (db *Database) Type(key string) string {
+	if _, ok := db.lookup(key); !ok {
+		return "none"
+	}
+
+	// Strings are the only supported type.
+	return "string"
+}
+
+// Rename renames src to dst along with its expiry, overwriting dst unless nx is set.
+// It returns false for exists if src doesn't exist, and false for renamed if it wasn't renamed because of nx.
+func (db *Database) Rename(src, dst string, nx bool) (renamed, exists bool) {
+	unlock := db.lockKeys(src, dst)
+	defer unlock()
+
+	srcShard := db.shard(src)
+	dstShard := db.shard(dst)
+
+	entry, ok := db.lookupLocked(srcShard, src)
+
+	if !ok {
+		return false, false
+	}
+
+	if _, ok := db.lookupLocked(dstShard, dst); ok && nx {
+		return false, true
+	}
+
+	if src == dst {
+		return true, true
+	}
+
+	srcShard.remove(src)
+	dstShard.put(dst, entry)
+	db.dirty.Add(1)
+
+	return true, true
+}
+
+// Copy copies the value and expiry of src to dst, unless dst exists and replace isn't set.
+// It returns whether the value was copied.
+func (db *Database) Copy(src, dst string, replace bool) bool {
+	unlock := db.lockKeys(src, dst)
+	defer unlock()
+
+	dstShard := db.shard(dst)
+
+	entry, ok := db.lookupLocked(db.shard(src), src)
+
+	if !ok {
+		return false
+	}
+
+	if _, ok := db.lookupLocked(dstShard, dst); ok && !replace {
+		return false
+	}
+
+	dstShard.put(dst, newEntry(entry.value, entry.expiry))
+	db.dirty.Add(1)
+
+	return true
+}
+
+// Touch records an access to each of the given keys and returns how many of them exist.
+func (db *Database) Touch(keys ...string) int {
+	count := 0
+
+	for _, key := range keys {
+		if entry, ok := db.lookup(key); ok {
+			entry.access.touch()
+			count++
+		}
+	}
+
+	return count
+}
+
+// randomKeyTries is how many times RandomKey picks a random shard before looking through all of them in order,
+// which only happens when most keys are expired.
+const randomKeyTries = 16
+
+// RandomKey returns a random key that didn't expire, or false if there's none.
+func (db *Database) RandomKey() (string, bool) {
+	sizes := make([]int, shardCount)
+	total := 0
+
+	for i, s := range db.shards {
+		s.mu.RLock()
+		sizes[i] = len(s.data)
+		s.mu.RUnlock()
+
+		total += sizes[i]
+	}
+
+	if total == 0 {
+		return "", false
+	}
+
+	// Shards are picked in proportion to their number of keys, so every key is about as likely to be picked.
+	for range randomKeyTries {
+		n := rand.IntN(total)
+		i := 0
+
+		for n >= sizes[i] {
+			n -= sizes[i]
+			i++
+		}
+
+		if key, ok := db.shards[i].randomKey(); ok {
+			return key, true
+		}
+	}
+
+	for _, s := range db.shards {
+		if key, ok := s.randomKey(); ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// randomKey returns a random key of the shard that didn't expire.
+func (s *shard) randomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var key string
+	found := false
+
+	s.randomKeys(false, func(k string) bool {
+		if s.data[k].expired() {
+			return true
+		}
+
+		key, found = k, true
+
+		return false
+	})
+
+	return key, found
+}
+
+// Size returns the number of keys in the database, including expired keys that weren't deleted yet.
+func (db *Database) Size() int {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	size := 0
+
+	for _, s := range db.shards {
+		size += len(s.data)
+	}
+
+	return size
+}
+
+// Keys returns the keys matching the glob-style pattern that aren't expired, in no particular order.
+func (db *Database) Keys(pattern string) []string {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	keys := make([]string, 0)
+
+	for _, s := range db.shards {
+		for key, entry := range s.data {
+			if !entry.expired() && glob.Match(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// scanHashBits is the number of bits of a key hash left once the bits picking its shard are dropped.
+const scanHashBits = 26
+
+// scanChunkSize is the most keys a chunk of a scan index holds before it's split in two.
+const scanChunkSize = 256
+
+// scanPosition returns the position of a key in the order SCAN visits keys, which is by shard, then by hash.
+// Since it only depends on the key, keys that are added or removed during a scan don't move the others,
+// so every key that exists for the whole scan is returned.
+func scanPosition(key string) uint64 {
+	h := keyHash(key)
+
+	return uint64(h%shardCount)<<scanHashBits | uint64(h/shardCount)
+}
+
+type scanItem struct {
+	position uint64
+	key      string
+}
+
+// compare orders items by position, then by key so that keys sharing a position have a fixed order too.
+func (a scanItem) compare(b scanItem) int {
+	if c := cmp.Compare(a.position, b.position); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a.key, b.key)
+}
+
+// scanIndex holds the keys of a shard ordered by their scan position. It's a sorted list of small sorted chunks,
+// so adding or removing a key only moves the items of one chunk, and a scan finds where to resume with binary searches.
+type scanIndex struct {
+	chunks [][]scanItem
+}
+
+// find returns the chunk an item belongs in and its index in the chunk, which is where it'd be inserted if not found.
+func (x *scanIndex) find(item scanItem) (chunk, i int, found bool) {
+	if len(x.chunks) == 0 {
+		return 0, 0, false
+	}
+
+	// The first chunk whose last item isn't before the item, or the last chunk if the item is after all of them.
+	chunk = sort.Search(len(x.chunks), func(c int) bool {
+		last := x.chunks[c][len(x.chunks[c])-1]
+		return last.compare(item) >= 0
+	})
+
+	if chunk == len(x.chunks) {
+		chunk--
+		return chunk, len(x.chunks[chunk]), false
+	}
+
+	i, found = slices.BinarySearchFunc(x.chunks[chunk], item, scanItem.compare)
+
+	return chunk, i, found
+}
+
+func (x *scanIndex) insert(key string) {
+	item := scanItem{position: scanPosition(key), key: key}
+
+	if len(x.chunks) == 0 {
+		x.chunks = [][]scanItem{{item}}
+		return
+	}
+
+	c, i, found := x.find(item)
+
+	if found {
+		return
+	}
+
+	x.chunks[c] = slices.Insert(x.chunks[c], i, item)
+
+	if len(x.chunks[c]) > scanChunkSize {
+		half := len(x.chunks[c]) / 2
+		right := slices.Clone(x.chunks[c][half:])
+
+		clear(x.chunks[c][half:])
+		x.chunks[c] = x.chunks[c][:half]
+		x.chunks = slices.Insert(x.chunks, c+1, right)
+	}
+}
+
+func (x *scanIndex) remove(key string) {
+	c, i, found := x.find(scanItem{position: scanPosition(key), key: key})
+
+	if !found {
+		return
+	}
+
+	x.chunks[c] = slices.Delete(x.chunks[c], i, i+1)
+
+	switch {
+	case len(x.chunks[c]) == 0:
+		x.chunks = slices.Delete(x.chunks, c, c+1)
+
+	// Merge small chunks with the next one so removals don't leave many tiny chunks behind.
+	case len(x.chunks[c]) < scanChunkSize/4 && c+1 < len(x.chunks) && len(x.chunks[c])+len(x.chunks[c+1]) <= scanChunkSize:
+		x.chunks[c] = append(x.chunks[c], x.chunks[c+1]...)
+		x.chunks = slices.Delete(x.chunks, c+1, c+2)
+	}
+}
+
+func (x *scanIndex) clone() scanIndex {
+	chunks := make([][]scanItem, len(x.chunks))
+
+	for i, chunk := range x.chunks {
+		chunks[i] = slices.Clone(chunk)
+	}
+
+	return scanIndex{chunks: chunks}
+}
+
+// ascend calls fn for each item at or after position in order, until fn returns false.
+func (x *scanIndex) ascend(position uint64, fn func(item scanItem) bool) {
+	c, i, _ := x.find(scanItem{position: position})
+
+	for ; c < len(x.chunks); c, i = c+1, 0 {
+		for _, item := range x.chunks[c][i:] {
+			if !fn(item) {
+				return
+			}
+		}
+	}
+}
+
+// Scan visits about count keys starting at the given cursor, returning the ones that aren't expired along with
+// the cursor to pass to continue the scan. A scan starts with cursor 0 and is done once it returns cursor 0.
+// Keys sharing the same position are always visited together, so slightly more than count keys may be visited.
+func (db *Database) Scan(cursor uint64, count int) (keys []string, next uint64) {
+	// count comes from clients, so it's only trusted to bound the work and not to size the allocation.
+	keys = make([]string, 0, min(count, 1024))
+	visited := 0
+
+	for i := int(cursor >> scanHashBits); i < shardCount; i++ {
+		s := db.shards[i]
+		last := uint64(0)
+
+		s.mu.RLock()
+
+		s.scan.ascend(cursor, func(item scanItem) bool {
+			if visited >= count && item.position != last {
+				next = item.position
+				return false
+			}
+
+			visited++
+			last = item.position
+
+			if !s.data[item.key].expired() {
+				keys = append(keys, item.key)
+			}
+
+			return true
+		})
+
+		s.mu.RUnlock()
+
+		// A shard is only left early once count keys were visited in it, so the position after them isn't 0.
+		if next != 0 {
+			return keys, next
+		}
+
+		// The next shard is scanned from its start.
+		cursor = uint64(i+1) << scanHashBits
+
+		if visited >= count && i+1 < shardCount {
+			return keys, cursor
+		}
+	}
+
+	return keys, 0
+}
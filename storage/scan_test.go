@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestScanIndex(t *testing.T) {
+	var x scanIndex
+
+	present := make(map[string]bool)
+
+	for range 20_000 {
+		key := "key:" + strconv.Itoa(rand.IntN(5_000))
+
+		if rand.IntN(3) == 0 {
+			x.remove(key)
+			delete(present, key)
+		} else {
+			x.insert(key)
+			present[key] = true
+		}
+	}
+
+	var got []scanItem
+
+	x.ascend(0, func(item scanItem) bool {
+		got = append(got, item)
+		return true
+	})
+
+	if len(got) != len(present) {
+		t.Fatalf("index has %d keys, want %d", len(got), len(present))
+	}
+
+	if !slices.IsSortedFunc(got, scanItem.compare) {
+		t.Error("index isn't sorted by scan position")
+	}
+
+	for _, item := range got {
+		if !present[item.key] {
+			t.Errorf("index has removed key %q", item.key)
+		}
+	}
+
+	for _, chunk := range x.chunks {
+		if len(chunk) == 0 || len(chunk) > scanChunkSize {
+			t.Errorf("index has a chunk of %d keys", len(chunk))
+		}
+	}
+}
+
+// scanAll runs a full scan and returns how many times each key was returned.
+func scanAll(db *Database, count int) map[string]int {
+	seen := make(map[string]int)
+	cursor := uint64(0)
+
+	for {
+		keys, next := db.Scan(cursor, count)
+
+		for _, key := range keys {
+			seen[key]++
+		}
+
+		if next == 0 {
+			return seen
+		}
+
+		cursor = next
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, count := range []int{1, 10, 1000, 100_000, math.MaxInt64} {
+		t.Run("count "+strconv.Itoa(count), func(t *testing.T) {
+			db := NewDatabase()
+
+			for i := range 5_000 {
+				db.Set("key:"+strconv.Itoa(i), "v", NeverExpires, SetDefault, false, false)
+			}
+
+			db.Set("expired", "v", NewMillisExpiry(-1), SetDefault, false, false)
+
+			seen := scanAll(db, count)
+
+			if len(seen) != 5_000 {
+				t.Errorf("scan returned %d keys, want 5000", len(seen))
+			}
+
+			for key, n := range seen {
+				if n != 1 {
+					t.Errorf("scan returned %q %d times without changes to the database", key, n)
+				}
+			}
+
+			if seen["expired"] != 0 {
+				t.Error("scan returned an expired key")
+			}
+		})
+	}
+}
+
+// TestScanConcurrentChanges checks that every key present for the whole scan is returned
+// while other keys are being added and deleted.
+func TestScanConcurrentChanges(t *testing.T) {
+	db := NewDatabase()
+
+	for i := range 5_000 {
+		db.Set("stable:"+strconv.Itoa(i), "v", NeverExpires, SetDefault, false, false)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for w := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				key := "churn:" + strconv.Itoa(w) + ":" + strconv.Itoa(i)
+				db.Set(key, "v", NeverExpires, SetDefault, false, false)
+
+				if i >= 100 {
+					db.Delete("churn:" + strconv.Itoa(w) + ":" + strconv.Itoa(i-100))
+				}
+			}
+		}()
+	}
+
+	for range 5 {
+		seen := scanAll(db, 10)
+
+		for i := range 5_000 {
+			if key := "stable:" + strconv.Itoa(i); seen[key] == 0 {
+				t.Errorf("scan missed %q", key)
+			}
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+// TestScanSamePosition checks that keys sharing a position are returned by the same call,
+// since the cursor can't point between them.
+func TestScanSamePosition(t *testing.T) {
+	positions := make(map[uint64]string)
+	var a, b string
+
+	for i := 0; a == ""; i++ {
+		key := strconv.Itoa(i)
+		position := scanPosition(key)
+
+		if other, ok := positions[position]; ok {
+			a, b = other, key
+		}
+
+		positions[position] = key
+	}
+
+	db := NewDatabase()
+	db.Set(a, "v", NeverExpires, SetDefault, false, false)
+	db.Set(b, "v", NeverExpires, SetDefault, false, false)
+
+	keys, _ := db.Scan(0, 1)
+
+	if len(keys) != 2 {
+		t.Errorf("Scan(0, 1) = %q, want both %q and %q", keys, a, b)
+	}
+}
+
+func TestClonedScanIndex(t *testing.T) {
+	db := NewDatabase()
+
+	for i := range 1_000 {
+		db.Set("key:"+strconv.Itoa(i), "v", NeverExpires, SetDefault, false, false)
+	}
+
+	clone := db.Clone()
+
+	for i := range 500 {
+		db.Delete("key:" + strconv.Itoa(i))
+	}
+
+	if seen := scanAll(clone, 10); len(seen) != 1_000 {
+		t.Errorf("scan of the clone returned %d keys, want 1000", len(seen))
+	}
+
+	if seen := scanAll(db, 10); len(seen) != 500 {
+		t.Errorf("scan of the original returned %d keys, want 500", len(seen))
+	}
+}
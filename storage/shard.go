@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// shardCount is the number of shards the keys of a database are partitioned into.
+const shardCount = 64
+
+// shard holds the keys that hash to it, guarded by its own lock.
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]Entry
+
+	// volatile holds the keys with an expiry, which are sampled by the active expiration.
+	volatile map[string]struct{}
+
+	// used is the estimated memory used by the keys of the shard.
+	used atomic.Int64
+
+	// scan holds the keys ordered the way SCAN visits them.
+	scan scanIndex
+}
+
+func newShard() *shard {
+	return &shard{
+		data:     make(map[string]Entry),
+		volatile: make(map[string]struct{}),
+	}
+}
+
+// put sets the entry of a key, it must be called while holding the lock.
+func (s *shard) put(key string, entry Entry) {
+	if previous, ok := s.data[key]; ok {
+		s.used.Add(-entrySize(key, previous))
+	} else {
+		s.scan.insert(key)
+	}
+
+	s.data[key] = entry
+	s.used.Add(entrySize(key, entry))
+
+	if entry.expiry.Expires {
+		s.volatile[key] = struct{}{}
+	} else {
+		delete(s.volatile, key)
+	}
+}
+
+// remove deletes a key, it must be called while holding the lock.
+func (s *shard) remove(key string) {
+	if entry, ok := s.data[key]; ok {
+		s.used.Add(-entrySize(key, entry))
+		s.scan.remove(key)
+	}
+
+	delete(s.data, key)
+	delete(s.volatile, key)
+}
+
+// randomKeys calls fn for the keys of the shard, or only the ones with an expiry if volatile is set,
+// starting from a random one until fn returns false. It must be called while holding the lock.
+func (s *shard) randomKeys(volatile bool, fn func(key string) bool) {
+	// Iterating over a map starts at a random position, which makes it a cheap random sample.
+	if volatile {
+		for key := range s.volatile {
+			if !fn(key) {
+				return
+			}
+		}
+
+		return
+	}
+
+	for key := range s.data {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// keyHash returns the FNV-1a hash of a key.
+func keyHash(key string) uint32 {
+	h := uint32(2166136261)
+
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+
+	return h
+}
+
+// shardIndex returns the index of the shard a key belongs to using the hash of the key.
+func shardIndex(key string) int {
+	return int(keyHash(key) % shardCount)
+}
+
+func (db *Database) shard(key string) *shard {
+	return db.shards[shardIndex(key)]
+}
+
+// lockKeys locks the shards of all the given keys so they can be changed atomically, and returns a function
+// that unlocks them. Shards are always locked in the same order so commands on several keys can't deadlock.
+func (db *Database) lockKeys(keys ...string) (unlock func()) {
+	indexes := make([]int, 0, len(keys))
+
+	for _, key := range keys {
+		indexes = append(indexes, shardIndex(key))
+	}
+
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		db.shards[i].mu.Lock()
+	}
+
+	return func() {
+		for _, i := range indexes {
+			db.shards[i].mu.Unlock()
+		}
+	}
+}
+
+func (db *Database) lockAll() {
+	for _, s := range db.shards {
+		s.mu.Lock()
+	}
+}
+
+func (db *Database) unlockAll() {
+	for _, s := range db.shards {
+		s.mu.Unlock()
+	}
+}
+
+func (db *Database) rlockAll() {
+	for _, s := range db.shards {
+		s.mu.RLock()
+	}
+}
+
+func (db *Database) runlockAll() {
+	for _, s := range db.shards {
+		s.mu.RUnlock()
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -50,19 +52,67 @@ func NewUnixMilliExpiry(millis int64) Expiry {
 type Entry struct {
 	value  string
 	expiry Expiry
+
+	// access is shared by the copies of the entry so reads can update it without the write lock.
+	access *accessInfo
+}
+
+func newEntry(value string, expiry Expiry) Entry {
+	return Entry{value: value, expiry: expiry, access: newAccessInfo()}
+}
+
+func (e Entry) expired() bool {
+	return e.expiry.Expires && time.Now().After(e.expiry.Time)
 }
 
-// Database is a simple, thread-safe, in-memory key-value store
+// Database is a simple, thread-safe, in-memory key-value store.
+// Keys are partitioned into shards with their own locks, so commands on different keys don't wait for each other.
 type Database struct {
-	data map[string]Entry
-	mu   *sync.Mutex
+	*database
+
+	// fromMaster is set for the view used by commands from the master, see FromMaster.
+	fromMaster bool
+}
+
+// database is the state shared by all the views of a Database.
+type database struct {
+	shards [shardCount]*shard
+
+	// dirty counts the changes made to the database, it only ever increases.
+	dirty atomic.Int64
+
+	// expiredKeys counts the keys deleted because they expired.
+	expiredKeys atomic.Int64
+	// expireCursor is the shard the next active expiration cycle starts from.
+	expireCursor atomic.Uint32
+
+	// evictedKeys counts the keys deleted to stay under the memory limit.
+	evictedKeys atomic.Int64
+
+	// replicaMode is set when the database belongs to a replica, which never deletes expired keys on its own.
+	replicaMode atomic.Bool
+
+	// expiredMu guards expiredQueue, which holds the keys deleted because they expired that weren't propagated yet.
+	expiredMu     sync.Mutex
+	expiredQueue  []string
+	expiredQueued atomic.Int64
 }
 
 func NewDatabase() *Database {
-	return &Database{
-		data: make(map[string]Entry),
-		mu:   &sync.Mutex{},
+	db := &Database{database: &database{}}
+
+	for i := range db.shards {
+		db.shards[i] = newShard()
 	}
+
+	return db
+}
+
+// FromMaster returns a view of the database for the commands a replica receives from its master.
+// The master decides when its keys expire, so these commands see expired keys as they're stored until
+// the master deletes them, otherwise a command the master ran on a live key would do nothing here.
+func (db *Database) FromMaster() *Database {
+	return &Database{database: db.database, fromMaster: true}
 }
 
 type SetMode int64
@@ -76,10 +126,12 @@ const (
 )
 
 func (db *Database) Set(key, value string, expiry Expiry, mode SetMode, keepTTL, get bool) (previous string, exists, isSet bool) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	s := db.shard(key)
 
-	entry, ok := db.data[key]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := db.lookupLocked(s, key)
 
 	if keepTTL {
 		expiry = entry.expiry
@@ -88,7 +140,8 @@ func (db *Database) Set(key, value string, expiry Expiry, mode SetMode, keepTTL,
 	shouldSet := !(mode == SetNX && ok) && !(mode == SetXX && !ok)
 
 	if shouldSet {
-		db.data[key] = Entry{value: value, expiry: expiry}
+		s.put(key, newEntry(value, expiry))
+		db.dirty.Add(1)
 	}
 
 	if get {
@@ -99,29 +152,140 @@ func (db *Database) Set(key, value string, expiry Expiry, mode SetMode, keepTTL,
 }
 
 func (db *Database) Get(key string) (string, bool) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	entry, ok := db.data[key]
+	entry, ok := db.lookup(key)
 
-	if entry.expiry.Expires && time.Now().After(entry.expiry.Time) {
-		delete(db.data, key)
-		return "", false
+	if ok {
+		entry.access.touch()
 	}
 
 	return entry.value, ok
 }
 
-func (db *Database) Delete(key string) bool {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// lookup returns the entry of a key, deleting it if it expired.
+func (db *Database) lookup(key string) (Entry, bool) {
+	s := db.shard(key)
 
-	_, ok := db.data[key]
+	s.mu.RLock()
+	entry, ok := s.data[key]
+	s.mu.RUnlock()
 
-	if ok {
-		delete(db.data, key)
-		return true
+	if !entry.expired() || db.fromMaster {
+		return entry, ok
+	}
+
+	if db.replicaMode.Load() {
+		return Entry{}, false
 	}
 
-	return false
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The key may have been replaced since it was read.
+	return db.lookupLocked(s, key)
+}
+
+// lookupLocked is like lookup but it must be called while holding the lock of the shard.
+func (db *Database) lookupLocked(s *shard, key string) (Entry, bool) {
+	entry, ok := s.data[key]
+
+	if ok && entry.expired() && !db.fromMaster {
+		if !db.replicaMode.Load() {
+			db.expire(s, key)
+		}
+
+		return Entry{}, false
+	}
+
+	return entry, ok
+}
+
+// Delete deletes the given keys at once and returns how many of them existed.
+func (db *Database) Delete(keys ...string) int {
+	unlock := db.lockKeys(keys...)
+	defer unlock()
+
+	deleted := 0
+
+	for _, key := range keys {
+		s := db.shard(key)
+
+		// Expired keys are deleted without being counted, unless the DEL comes from the master which decides when they expire.
+		if entry, ok := s.data[key]; ok && entry.expired() && !db.fromMaster {
+			if db.replicaMode.Load() {
+				s.remove(key)
+				db.dirty.Add(1)
+			} else {
+				db.expire(s, key)
+			}
+		} else if ok {
+			s.remove(key)
+			db.dirty.Add(1)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// Range calls fn for each key in the database along with its value and expiry while holding the lock of every shard,
+// so it sees the database at a single point in time. Iteration stops early if fn returns false.
+func (db *Database) Range(fn func(key, value string, expiry Expiry) bool) {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	for _, s := range db.shards {
+		for key, entry := range s.data {
+			if !fn(key, entry.value, entry.expiry) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a point-in-time copy of the database which can be used while the original one keeps changing.
+func (db *Database) Clone() *Database {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	clone := NewDatabase()
+
+	for i, s := range db.shards {
+		c := clone.shards[i]
+		c.data = maps.Clone(s.data)
+		c.volatile = maps.Clone(s.volatile)
+		c.used.Store(s.used.Load())
+		c.scan = s.scan.clone()
+	}
+
+	clone.dirty.Store(db.dirty.Load())
+
+	return clone
+}
+
+// Replace replaces all the contents of the database with the contents of other, which shouldn't be used afterwards.
+func (db *Database) Replace(other *Database) {
+	other.lockAll()
+	shards := other.shards
+	other.unlockAll()
+
+	db.lockAll()
+	defer db.unlockAll()
+
+	added := 0
+
+	// Both databases partition keys the same way, so the contents of each shard can be taken as is.
+	for i, s := range db.shards {
+		s.data = shards[i].data
+		s.volatile = shards[i].volatile
+		s.scan = shards[i].scan
+		s.used.Store(shards[i].used.Load())
+		added += len(s.data)
+	}
+
+	db.dirty.Add(int64(added) + 1)
+}
+
+// Dirty returns the number of changes made to the database since it was created.
+func (db *Database) Dirty() int64 {
+	return db.dirty.Load()
 }
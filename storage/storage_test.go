@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+// benchmarkKeys is the number of keys the benchmarks work on.
+const benchmarkKeys = 100_000
+
+func newBenchmarkDatabase(b *testing.B) (*Database, []string) {
+	b.Helper()
+
+	db := NewDatabase()
+	keys := make([]string, benchmarkKeys)
+
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		db.Set(keys[i], "value", NeverExpires, SetDefault, false, false)
+	}
+
+	return db, keys
+}
+
+// runParallel runs op with a random key from every goroutine of b.RunParallel,
+// run with -cpu 1,2,4,... to see how throughput scales with the cores.
+func runParallel(b *testing.B, op func(db *Database, key string, i int)) {
+	db, keys := newBenchmarkDatabase(b)
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.IntN(len(keys))
+
+		for pb.Next() {
+			op(db, keys[i%len(keys)], i)
+			i++
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	runParallel(b, func(db *Database, key string, i int) {
+		db.Get(key)
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	runParallel(b, func(db *Database, key string, i int) {
+		db.Set(key, "value", NeverExpires, SetDefault, false, false)
+	})
+}
+
+// BenchmarkMixed runs one SET for every nine GETs.
+func BenchmarkMixed(b *testing.B) {
+	runParallel(b, func(db *Database, key string, i int) {
+		if i%10 == 0 {
+			db.Set(key, "value", NeverExpires, SetDefault, false, false)
+		} else {
+			db.Get(key)
+		}
+	})
+}